@@ -0,0 +1,441 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+// The backup directory holds a description of every backup in meta/<id> and
+// the files of all backups in shared/, named by the SHA-256 of their content,
+// so a table present in several backups is stored once. Shared files are
+// copies, so they don't share inodes with the tree. A backup exists once its
+// description is written; shared files no backup references are garbage.
+// NEXT_ID holds the ID of the next backup, so IDs aren't reused even after
+// every backup is purged.
+const (
+	metaDir    = "meta"
+	sharedDir  = "shared"
+	stagingDir = "staging"
+	nextIDFile = "NEXT_ID"
+)
+
+type BackupInfo struct {
+	ID        int       `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Size is the total size of the backed up files, shared ones included.
+	Size  int64        `json:"size"`
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile is a file of the tree, Path being relative to the tree's
+// directory.
+type BackupFile struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+type Engine struct {
+	mu  sync.Mutex
+	fs  vfs.FS
+	dir string
+}
+
+// Open opens or creates the backup directory dir on fs. Trees backed up by
+// the engine must use the same file system.
+func Open(fs vfs.FS, dir string) (*Engine, error) {
+	for _, subdir := range []string{metaDir, sharedDir} {
+		if err := fs.MkdirAll(filepath.Join(dir, subdir), 0770); err != nil {
+			return nil, err
+		}
+	}
+	return &Engine{fs: fs, dir: dir}, nil
+}
+
+// CreateBackup checkpoints the tree and adds the tables that aren't in the
+// backup directory yet, so only the tables created since the previous backup
+// are copied.
+func (e *Engine) CreateBackup(tree *lsm_tree.LSMTree) (*BackupInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	info, err := e.createBackup(tree)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreatingBackup, err)
+	}
+	return info, nil
+}
+
+func (e *Engine) createBackup(tree *lsm_tree.LSMTree) (*BackupInfo, error) {
+	backups, err := e.listBackups()
+	if err != nil {
+		return nil, err
+	}
+	id, err := e.nextID(backups)
+	if err != nil {
+		return nil, err
+	}
+	info := &BackupInfo{ID: id, Timestamp: time.Now()}
+
+	// The ID is taken before the backup is written, so a failed backup
+	// doesn't leave it to the next one.
+	err = vfs.WriteFileAtomic(e.fs, filepath.Join(e.dir, nextIDFile), []byte(strconv.Itoa(id+1)))
+	if err != nil {
+		return nil, err
+	}
+
+	staging := filepath.Join(e.dir, stagingDir)
+	if err = e.clearStaging(); err != nil {
+		return nil, err
+	}
+	defer e.clearStaging()
+
+	if err = tree.Checkpoint(staging); err != nil {
+		return nil, err
+	}
+
+	files := []string{common.ManifestFile}
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		names, err := e.fs.ReadDir(filepath.Join(staging, subdir))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			files = append(files, filepath.Join(subdir, name))
+		}
+	}
+
+	for _, path := range files {
+		file, err := e.addSharedFile(staging, path)
+		if err != nil {
+			return nil, err
+		}
+		info.Files = append(info.Files, *file)
+		info.Size += file.Size
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	err = vfs.WriteFileAtomic(e.fs, filepath.Join(e.dir, metaDir, strconv.Itoa(info.ID)), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// addSharedFile copies the file at path in the checkpoint into the shared
+// directory unless a file with the same content is there already. Every file
+// is hashed: table numbers are unique only within a tree, and several trees
+// can be backed up into the same directory.
+func (e *Engine) addSharedFile(checkpoint string, path string) (*BackupFile, error) {
+	source := filepath.Join(checkpoint, path)
+	hash, size, err := hashFile(e.fs, source)
+	if err != nil {
+		return nil, err
+	}
+	file := &BackupFile{Path: path, Hash: hash, Size: size}
+
+	sharedPath := filepath.Join(e.dir, sharedDir, file.Hash)
+	if shared, err := e.fs.Open(sharedPath); err == nil {
+		return file, shared.Close()
+	}
+
+	tempPath := sharedPath + common.TempSuffix
+	_ = e.fs.Remove(tempPath)
+	if err = vfs.CopyFile(e.fs, source, tempPath); err != nil {
+		return nil, err
+	}
+	if err = e.fs.Rename(tempPath, sharedPath); err != nil {
+		return nil, err
+	}
+	if err = e.fs.SyncDir(filepath.Dir(sharedPath)); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// clearStaging removes the checkpoint a backup is made from, including one
+// left by a crash.
+func (e *Engine) clearStaging() error {
+	staging := filepath.Join(e.dir, stagingDir)
+	for _, subdir := range []string{".", common.MetaDataDir, common.DataDir} {
+		dir := filepath.Join(staging, subdir)
+		names, err := e.fs.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err = e.fs.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextID returns the ID of the next backup: the one in NEXT_ID, or one past
+// the newest backup if that is greater, e.g. in a directory created before
+// NEXT_ID existed.
+func (e *Engine) nextID(backups []BackupInfo) (int, error) {
+	id := 1
+	if len(backups) > 0 {
+		id = backups[len(backups)-1].ID + 1
+	}
+
+	file, err := e.fs.Open(filepath.Join(e.dir, nextIDFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return id, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	next, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", ErrCorruptedFile, nextIDFile, err)
+	}
+	return max(id, next), nil
+}
+
+// ListBackups returns the backups ordered by ID, the oldest first.
+func (e *Engine) ListBackups() ([]BackupInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.listBackups()
+}
+
+func (e *Engine) listBackups() ([]BackupInfo, error) {
+	names, err := e.fs.ReadDir(filepath.Join(e.dir, metaDir))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupInfo, 0, len(names))
+	for _, name := range names {
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		info, err := e.readBackupInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, *info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID < backups[j].ID
+	})
+	return backups, nil
+}
+
+func (e *Engine) readBackupInfo(id int) (*BackupInfo, error) {
+	file, err := e.fs.Open(filepath.Join(e.dir, metaDir, strconv.Itoa(id)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %d", ErrBackupNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("%w: backup %d: %w", ErrCorruptedFile, id, err)
+	}
+	return info, nil
+}
+
+// PurgeOldBackups deletes all but the keep newest backups and the shared
+// files only they referenced.
+func (e *Engine) PurgeOldBackups(keep int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	backups, err := e.listBackups()
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > max(keep, 0) {
+		err = e.fs.Remove(filepath.Join(e.dir, metaDir, strconv.Itoa(backups[0].ID)))
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	if err = e.fs.SyncDir(filepath.Join(e.dir, metaDir)); err != nil {
+		return err
+	}
+
+	return e.collectGarbage(backups)
+}
+
+// collectGarbage removes the shared files no backup references, including
+// ones left by interrupted backups and purges.
+func (e *Engine) collectGarbage(backups []BackupInfo) error {
+	live := make(map[string]struct{})
+	for _, info := range backups {
+		for _, file := range info.Files {
+			live[file.Hash] = struct{}{}
+		}
+	}
+
+	dir := filepath.Join(e.dir, sharedDir)
+	names, err := e.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := live[name]; ok {
+			continue
+		}
+		if err = e.fs.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return e.fs.SyncDir(dir)
+}
+
+// VerifyBackup checks the size and the checksum of every file of the backup.
+func (e *Engine) VerifyBackup(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	info, err := e.readBackupInfo(id)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range info.Files {
+		hash, size, err := hashFile(e.fs, filepath.Join(e.dir, sharedDir, file.Hash))
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrCorruptedFile, file.Path, err)
+		}
+		if hash != file.Hash || size != file.Size {
+			return fmt.Errorf("%w: %s", ErrCorruptedFile, file.Path)
+		}
+	}
+
+	return nil
+}
+
+// RestoreBackup recreates the tree of the backup in dir, which must not exist
+// yet or contain no files. The tree can be opened once it returns. Checksums
+// aren't checked here, VerifyBackup does that.
+func (e *Engine) RestoreBackup(id int, dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	info, err := e.readBackupInfo(id)
+	if err != nil {
+		return err
+	}
+
+	for _, subdir := range []string{".", common.MetaDataDir, common.DataDir} {
+		names, err := e.fs.ReadDir(filepath.Join(dir, subdir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %w", ErrRestoring, err)
+		}
+		if len(names) > 0 {
+			return fmt.Errorf("%w: %s", ErrTargetNotEmpty, dir)
+		}
+	}
+
+	if err = e.restore(info, dir); err != nil {
+		for _, file := range info.Files {
+			_ = e.fs.Remove(filepath.Join(dir, file.Path))
+		}
+		return fmt.Errorf("%w: %w", ErrRestoring, err)
+	}
+	return nil
+}
+
+// restore writes the manifest last, so an interrupted restore leaves no tree
+// that could be opened.
+func (e *Engine) restore(info *BackupInfo, dir string) error {
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		if err := e.fs.MkdirAll(filepath.Join(dir, subdir), 0770); err != nil {
+			return err
+		}
+	}
+
+	var manifest *BackupFile
+	for i, file := range info.Files {
+		if file.Path == common.ManifestFile {
+			manifest = &info.Files[i]
+			continue
+		}
+		err := vfs.CopyFile(e.fs, filepath.Join(e.dir, sharedDir, file.Hash), filepath.Join(dir, file.Path))
+		if err != nil {
+			return err
+		}
+	}
+	if manifest == nil {
+		return fmt.Errorf("%w: backup %d has no manifest", ErrCorruptedFile, info.ID)
+	}
+
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		if err := e.fs.SyncDir(filepath.Join(dir, subdir)); err != nil {
+			return err
+		}
+	}
+
+	file, err := e.fs.Open(filepath.Join(e.dir, sharedDir, manifest.Hash))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	return vfs.WriteFileAtomic(e.fs, filepath.Join(dir, common.ManifestFile), data)
+}
+
+func hashFile(fs vfs.FS, path string) (string, int64, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
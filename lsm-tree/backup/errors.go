@@ -0,0 +1,11 @@
+package backup
+
+import "errors"
+
+var (
+	ErrBackupNotFound = errors.New("backup not found")
+	ErrCorruptedFile  = errors.New("backup file is corrupted")
+	ErrCreatingBackup = errors.New("error creating backup")
+	ErrRestoring      = errors.New("error restoring backup")
+	ErrTargetNotEmpty = errors.New("restore target is not empty")
+)
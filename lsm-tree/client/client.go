@@ -0,0 +1,285 @@
+// Package client talks to a tree served by the server package.
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/resp"
+)
+
+type Options struct {
+	// Up to MaxIdleConns connections are kept open between calls. Zero
+	// means 2.
+	MaxIdleConns int
+	DialTimeout  time.Duration
+}
+
+// Client is safe for concurrent use: every call takes an idle connection or
+// opens a new one.
+type Client struct {
+	addr   string
+	opts   Options
+	dialer net.Dialer
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+type conn struct {
+	netConn net.Conn
+	r       *resp.Reader
+	w       *resp.Writer
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// ScanOptions selects keys like lsm_tree.SearchRangeOptions: the zero value
+// selects [keyL, keyR] in ascending order. The server returns at most 1000
+// keys unless Limit, which can't exceed 10000, is set; the next page of a
+// range starts after the last key, with an exclusive bound.
+type ScanOptions struct {
+	LeftBound  lsm_tree.Bound
+	RightBound lsm_tree.Bound
+	Limit      int
+	Reverse    bool
+}
+
+// Dial connects to the server at addr and checks that it replies.
+func Dial(ctx context.Context, addr string, opts Options) (*Client, error) {
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = 2
+	}
+
+	c := &Client{
+		addr:   addr,
+		opts:   opts,
+		dialer: net.Dialer{Timeout: opts.DialTimeout},
+	}
+	if err := c.Ping(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the idle connections; the ones in use are closed when their
+// calls finish.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, cn := range c.idle {
+		_ = cn.netConn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	reply, err := c.do(ctx, []string{"PING"})
+	if err != nil {
+		return err
+	}
+	return expectSimple(reply, "PONG")
+}
+
+func (c *Client) Get(ctx context.Context, key string) (string, bool, error) {
+	reply, err := c.do(ctx, []string{"GET", key})
+	if err != nil {
+		return "", false, err
+	}
+	return parseGet(reply)
+}
+
+func (c *Client) Put(ctx context.Context, key string, value string) error {
+	reply, err := c.do(ctx, []string{"SET", key, value})
+	if err != nil {
+		return err
+	}
+	return expectSimple(reply, "OK")
+}
+
+// Delete deletes the keys at once.
+func (c *Client) Delete(ctx context.Context, keys ...string) error {
+	reply, err := c.do(ctx, append([]string{"DEL"}, keys...))
+	if err != nil {
+		return err
+	}
+	return expectInteger(reply)
+}
+
+func (c *Client) Scan(ctx context.Context, keyL string, keyR string, opts ScanOptions) ([]KeyValue, error) {
+	args := []string{"SCAN", formatBound(keyL, opts.LeftBound, "-"), formatBound(keyR, opts.RightBound, "+")}
+	if opts.Limit > 0 {
+		args = append(args, "LIMIT", fmt.Sprint(opts.Limit))
+	}
+	if opts.Reverse {
+		args = append(args, "REV")
+	}
+
+	reply, err := c.do(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if err = replyError(reply); err != nil {
+		return nil, err
+	}
+	if reply.Kind != resp.Array || len(reply.Array)%2 != 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, reply.Kind)
+	}
+
+	kvs := make([]KeyValue, 0, len(reply.Array)/2)
+	for i := 0; i < len(reply.Array); i += 2 {
+		kvs = append(kvs, KeyValue{Key: reply.Array[i].Str, Value: reply.Array[i+1].Str})
+	}
+	return kvs, nil
+}
+
+func formatBound(key string, bound lsm_tree.Bound, unbounded string) string {
+	switch bound {
+	case lsm_tree.Unbounded:
+		return unbounded
+	case lsm_tree.Exclusive:
+		return "(" + key
+	}
+	return "[" + key
+}
+
+func (c *Client) do(ctx context.Context, args []string) (resp.Reply, error) {
+	replies, err := c.roundTrip(ctx, [][]string{args})
+	if err != nil {
+		return resp.Reply{}, err
+	}
+	return replies[0], nil
+}
+
+// roundTrip sends the commands at once and reads their replies. The
+// connection is dropped if anything fails, as its state is unknown then.
+func (c *Client) roundTrip(ctx context.Context, cmds [][]string) ([]resp.Reply, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = cn.netConn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		_ = cn.netConn.SetDeadline(time.Now())
+	})
+
+	replies, err := cn.roundTrip(cmds)
+	interrupted := !stop()
+	if err != nil {
+		_ = cn.netConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	// The deadline of an interrupted connection may still be moved.
+	if interrupted {
+		_ = cn.netConn.Close()
+	} else {
+		c.put(cn)
+	}
+	return replies, nil
+}
+
+func (cn *conn) roundTrip(cmds [][]string) ([]resp.Reply, error) {
+	for _, args := range cmds {
+		if err := cn.w.WriteCommand(args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]resp.Reply, len(cmds))
+	for i := range replies {
+		var err error
+		if replies[i], err = cn.r.ReadReply(); err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	netConn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{netConn: netConn, r: resp.NewReader(netConn), w: resp.NewWriter(netConn)}, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= c.opts.MaxIdleConns {
+		_ = cn.netConn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func parseGet(reply resp.Reply) (string, bool, error) {
+	if err := replyError(reply); err != nil {
+		return "", false, err
+	}
+	if reply.Kind != resp.BulkString {
+		return "", false, fmt.Errorf("%w: %q", ErrUnexpectedReply, reply.Kind)
+	}
+	return reply.Str, !reply.Null, nil
+}
+
+func expectSimple(reply resp.Reply, s string) error {
+	if err := replyError(reply); err != nil {
+		return err
+	}
+	if reply.Kind != resp.SimpleString || reply.Str != s {
+		return fmt.Errorf("%w: %q %q", ErrUnexpectedReply, reply.Kind, reply.Str)
+	}
+	return nil
+}
+
+func expectInteger(reply resp.Reply) error {
+	if err := replyError(reply); err != nil {
+		return err
+	}
+	if reply.Kind != resp.Integer {
+		return fmt.Errorf("%w: %q", ErrUnexpectedReply, reply.Kind)
+	}
+	return nil
+}
+
+func replyError(reply resp.Reply) error {
+	if reply.Kind == resp.Error {
+		return fmt.Errorf("%w: %s", ErrServer, reply.Str)
+	}
+	return nil
+}
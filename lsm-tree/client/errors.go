@@ -0,0 +1,9 @@
+package client
+
+import "errors"
+
+var (
+	ErrClosed          = errors.New("client is closed")
+	ErrServer          = errors.New("server error")
+	ErrUnexpectedReply = errors.New("unexpected reply")
+)
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Batch collects writes that Apply sends as one transaction, which the
+// server applies as a single lsm_tree.Batch.
+type Batch struct {
+	cmds [][]string
+}
+
+func (b *Batch) Put(key string, value string) {
+	b.cmds = append(b.cmds, []string{"SET", key, value})
+}
+
+func (b *Batch) Delete(keys ...string) {
+	b.cmds = append(b.cmds, append([]string{"DEL"}, keys...))
+}
+
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+func (c *Client) Apply(ctx context.Context, b *Batch) error {
+	cmds := make([][]string, 0, len(b.cmds)+2)
+	cmds = append(cmds, []string{"MULTI"})
+	cmds = append(cmds, b.cmds...)
+	cmds = append(cmds, []string{"EXEC"})
+
+	replies, err := c.roundTrip(ctx, cmds)
+	if err != nil {
+		return err
+	}
+	// A rejected command is reported rather than the EXECABORT it causes.
+	for _, reply := range replies[:len(replies)-1] {
+		if err = replyError(reply); err != nil {
+			return err
+		}
+	}
+
+	exec := replies[len(replies)-1]
+	if err = replyError(exec); err != nil {
+		return err
+	}
+	if len(exec.Array) != len(b.cmds) {
+		return fmt.Errorf("%w: %d results for %d commands", ErrUnexpectedReply, len(exec.Array), len(b.cmds))
+	}
+	return nil
+}
+
+// Pipeline queues commands that Exec sends at once, without waiting for
+// the reply to each of them.
+type Pipeline struct {
+	client *Client
+	cmds   [][]string
+}
+
+// Result is the outcome of a queued command. Value and Found are only set
+// for Get.
+type Result struct {
+	Value string
+	Found bool
+	Err   error
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+func (p *Pipeline) Get(key string) {
+	p.cmds = append(p.cmds, []string{"GET", key})
+}
+
+func (p *Pipeline) Put(key string, value string) {
+	p.cmds = append(p.cmds, []string{"SET", key, value})
+}
+
+func (p *Pipeline) Delete(keys ...string) {
+	p.cmds = append(p.cmds, append([]string{"DEL"}, keys...))
+}
+
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec runs the queued commands in order and returns their results, also
+// in order, and empties the pipeline. The error is set if the results
+// couldn't be read; failures of single commands are in their results.
+func (p *Pipeline) Exec(ctx context.Context) ([]Result, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	replies, err := p.client.roundTrip(ctx, cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(cmds))
+	for i, reply := range replies {
+		switch cmds[i][0] {
+		case "GET":
+			results[i].Value, results[i].Found, results[i].Err = parseGet(reply)
+		case "SET":
+			results[i].Err = expectSimple(reply, "OK")
+		case "DEL":
+			results[i].Err = expectInteger(reply)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,59 @@
+package lsm_tree
+
+import (
+	"fmt"
+
+	"hw1/internal/sstable"
+)
+
+// ApproximateSize estimates the number of bytes the keys in [start, end]
+// occupy. Tombstones and shadowed versions are counted as well.
+func (l *LSMTree) ApproximateSize(start string, end string) (int64, error) {
+	_, size, err := l.approximateRange(start, end)
+	return size, err
+}
+
+// ApproximateCount estimates the number of keys in [start, end] using only
+// the sstable indexes, so deleted and overwritten keys may be counted more
+// than once.
+func (l *LSMTree) ApproximateCount(start string, end string) (int, error) {
+	count, _, err := l.approximateRange(start, end)
+	return count, err
+}
+
+func (l *LSMTree) approximateRange(start string, end string) (int, int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, 0, ErrClosed
+	}
+
+	if l.cmp.Compare(start, end) > 0 {
+		return 0, 0, ErrInvalidRange
+	}
+
+	count, size := 0, int64(0)
+	for key, element := range l.ramComponent {
+		if l.inBounds(key, start, end, sstable.RangeOptions{}) {
+			count++
+			size += element.EncodedSize()
+		}
+	}
+	for key := range l.ramComponentRemoved {
+		if l.inBounds(key, start, end, sstable.RangeOptions{}) {
+			count++
+			size += (&sstable.TableElement{Value: key, IsTombstone: true}).EncodedSize()
+		}
+	}
+
+	for _, table := range l.allTables() {
+		tableCount, tableSize, err := table.ApproximateRange(start, end)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %w", ErrSearching, err)
+		}
+		count += tableCount
+		size += tableSize
+	}
+
+	return count, size, nil
+}
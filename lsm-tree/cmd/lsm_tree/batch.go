@@ -0,0 +1,58 @@
+package lsm_tree
+
+import (
+	"context"
+
+	"hw1/internal/sstable"
+)
+
+// Batch collects writes that Apply makes visible to readers at once. Later
+// writes of a key in the batch override earlier ones.
+type Batch struct {
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	element sstable.TableElement
+	deleted bool
+}
+
+func (b *Batch) Put(key string, value string) {
+	b.writes = append(b.writes, batchWrite{element: sstable.TableElement{Value: key, Data: value}})
+}
+
+func (b *Batch) Delete(key string) {
+	b.writes = append(b.writes, batchWrite{element: sstable.TableElement{Value: key}, deleted: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.writes)
+}
+
+func (b *Batch) Reset() {
+	b.writes = b.writes[:0]
+}
+
+func (l *LSMTree) Apply(b *Batch) error {
+	return l.ApplyContext(context.Background(), b)
+}
+
+// ApplyContext writes the batch under a single acquisition of the tree lock.
+// The RAM component is flushed only after the whole batch is in it, so the
+// batch ends up in a single table and no crash can keep a part of it.
+func (l *LSMTree) ApplyContext(ctx context.Context, b *Batch) error {
+	return l.write(ctx, newTrace(OperationApply, ""), func() error {
+		if err := l.checkWritable(); err != nil {
+			return err
+		}
+
+		for _, w := range b.writes {
+			if w.deleted {
+				l.markRemoved(w.element.Value)
+			} else {
+				l.insert(w.element)
+			}
+		}
+		return l.flushFullRAMComponent()
+	})
+}
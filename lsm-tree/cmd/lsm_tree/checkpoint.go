@@ -0,0 +1,121 @@
+package lsm_tree
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"hw1/internal/common"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+// Checkpoint flushes the RAM component and creates in dir a consistent copy
+// of the tree that can be opened on its own. The directory must not exist yet
+// or must be empty. Tables are immutable, so their files are hard-linked, or
+// copied if linking isn't supported, e.g. across file systems. The tree lock
+// is held only to flush and to take the list of tables: tables merged away
+// while the files are linked are removed once the checkpoint is done. A tree
+// opened read-only or switched to the read-only mode by a background error is
+// checkpointed too, as long as its RAM component is empty.
+func (l *LSMTree) Checkpoint(dir string) error {
+	for _, subdir := range []string{".", common.MetaDataDir, common.DataDir} {
+		names, err := l.fs.ReadDir(filepath.Join(dir, subdir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %w", ErrCheckpoint, err)
+		}
+		if len(names) > 0 {
+			return fmt.Errorf("%w: %s is not empty", ErrCheckpoint, dir)
+		}
+	}
+
+	tables, manifest, err := l.pinTables()
+	if err != nil {
+		return err
+	}
+	defer l.unpinTables()
+
+	created := make([]string, 0)
+	err = l.checkpoint(dir, tables, manifest, &created)
+	if err != nil {
+		for _, path := range created {
+			_ = l.fs.Remove(path)
+		}
+		return fmt.Errorf("%w: %w", ErrCheckpoint, err)
+	}
+
+	return nil
+}
+
+// pinTables flushes the RAM component and returns the live tables with the
+// manifest listing them. Their files aren't removed until unpinTables.
+func (l *LSMTree) pinTables() ([]*sstable.SSTable, []byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, nil, ErrClosed
+	}
+
+	if len(l.ramComponent)+len(l.ramComponentRemoved) > 0 {
+		if err := l.checkWritable(); err != nil {
+			return nil, nil, err
+		}
+		err := l.flushRAMComponent()
+		if err != nil {
+			l.setBackgroundError(err)
+			return nil, nil, fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+		}
+	}
+
+	manifest, err := l.encodeManifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrCheckpoint, err)
+	}
+	l.checkpoints++
+	return l.allTables(), manifest, nil
+}
+
+func (l *LSMTree) unpinTables() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.checkpoints--
+	if l.checkpoints == 0 {
+		tables := l.pendingRemoval
+		l.pendingRemoval = nil
+		l.removeTables(tables)
+	}
+}
+
+// checkpoint records the files it creates, so they can be removed on failure.
+func (l *LSMTree) checkpoint(dir string, tables []*sstable.SSTable, manifest []byte, created *[]string) error {
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		if err := l.fs.MkdirAll(filepath.Join(dir, subdir), 0770); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tables {
+		number, err := tableNumber(table)
+		if err != nil {
+			return err
+		}
+		metaPath, dataPath := tablePaths(dir, number)
+
+		for _, link := range [][2]string{{table.MetaPath(), metaPath}, {table.DataPath(), dataPath}} {
+			if err = vfs.LinkOrCopy(l.fs, link[0], link[1]); err != nil {
+				return err
+			}
+			*created = append(*created, link[1])
+		}
+	}
+
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		if err := l.fs.SyncDir(filepath.Join(dir, subdir)); err != nil {
+			return err
+		}
+	}
+
+	return vfs.WriteFileAtomic(l.fs, filepath.Join(dir, common.ManifestFile), manifest)
+}
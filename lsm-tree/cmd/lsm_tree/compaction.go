@@ -0,0 +1,210 @@
+package lsm_tree
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"hw1/internal/sstable"
+)
+
+type CompactionProgress struct {
+	InputTables    int
+	ElementsTotal  int
+	ElementsMerged int
+}
+
+type ProgressFunc func(CompactionProgress)
+
+type runPosition struct {
+	level int
+	idx   int
+}
+
+// CompactRange merges every run overlapping [start, end] into the
+// bottommost level, dropping tombstones and shadowed versions. Runs
+// overlapping the chosen ones are taken as well, so moving their data below
+// the remaining runs can't change the visible state of any key.
+func (l *LSMTree) CompactRange(ctx context.Context, start string, end string, progress ProgressFunc) error {
+	if err := l.mu.LockContext(ctx); err != nil {
+		return err
+	}
+	defer l.mu.Unlock()
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	if l.cmp.Compare(start, end) > 0 {
+		return ErrInvalidRange
+	}
+	return l.compactAndCheck(ctx, l.overlappingRuns(start, end), progress)
+}
+
+func (l *LSMTree) CompactAll(ctx context.Context, progress ProgressFunc) error {
+	if err := l.mu.LockContext(ctx); err != nil {
+		return err
+	}
+	defer l.mu.Unlock()
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	positions := make([]runPosition, 0)
+	for level := range l.levels {
+		for i := range l.levels[level] {
+			positions = append(positions, runPosition{level: level, idx: i})
+		}
+	}
+	return l.compactAndCheck(ctx, positions, progress)
+}
+
+// compactAndCheck switches the tree to the read-only mode if the compaction
+// failed for a reason other than cancellation.
+func (l *LSMTree) compactAndCheck(ctx context.Context, positions []runPosition, progress ProgressFunc) error {
+	err := l.compact(ctx, positions, progress)
+	if err != nil && ctx.Err() == nil {
+		l.setBackgroundError(err)
+	}
+	return err
+}
+
+func (l *LSMTree) overlappingRuns(start string, end string) []runPosition {
+	selected := make(map[runPosition]struct{})
+	for {
+		added := false
+		for level := range l.levels {
+			for i, r := range l.levels[level] {
+				position := runPosition{level: level, idx: i}
+				if _, ok := selected[position]; ok {
+					continue
+				}
+				if l.cmp.Compare(r.largest(), start) < 0 || l.cmp.Compare(r.smallest(), end) > 0 {
+					continue
+				}
+
+				selected[position] = struct{}{}
+				added = true
+				if l.cmp.Compare(r.smallest(), start) < 0 {
+					start = r.smallest()
+				}
+				if l.cmp.Compare(r.largest(), end) > 0 {
+					end = r.largest()
+				}
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	positions := make([]runPosition, 0, len(selected))
+	for position := range selected {
+		positions = append(positions, position)
+	}
+	return positions
+}
+
+func (l *LSMTree) compact(ctx context.Context, positions []runPosition, progress ProgressFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+
+	// Rewriting a single run of the bottom level changes nothing unless it
+	// drops tombstones or expired keys, runs the compaction filter or resolves
+	// merge operands, which only a tree with a merge operator has.
+	bottomLevel := len(l.levels) - 1
+	if len(positions) == 1 && positions[0].level == bottomLevel && l.compactionFilter == nil && l.mergeOperator == nil {
+		r := l.levels[positions[0].level][positions[0].idx]
+		if r.tombstones() == 0 && r.expiring() == 0 {
+			return nil
+		}
+	}
+
+	// Merging expects the tables ordered from the oldest to the newest.
+	slices.SortFunc(positions, func(a, b runPosition) int {
+		if a.level != b.level {
+			return b.level - a.level
+		}
+		return a.idx - b.idx
+	})
+
+	tables := make([]*sstable.SSTable, 0)
+	info := CompactionInfo{Level: bottomLevel}
+	elementsTotal := 0
+	for _, position := range positions {
+		r := l.levels[position.level][position.idx]
+		tables = append(tables, r...)
+		info.Inputs = append(info.Inputs, tableInfos(position.level, r)...)
+		elementsTotal += r.size()
+	}
+
+	opts := sstable.MergeOptions{
+		DropTombstones: true,
+		TargetFileSize: l.targetFileSize,
+		Subcompactions: l.maxSubcompactions,
+		Now:            l.now().UnixNano(),
+		Filter:         l.mergeFilter(bottomLevel),
+		MergeOperator:  l.mergeOperator,
+	}
+	if progress != nil {
+		opts.Progress = func(elementsMerged int) {
+			progress(CompactionProgress{
+				InputTables:    len(tables),
+				ElementsTotal:  elementsTotal,
+				ElementsMerged: elementsMerged,
+			})
+		}
+	}
+
+	l.listener.compactionBegin(info)
+	start := time.Now()
+	newRun, err := sstable.Merge(ctx, l.fs, tables, l.cmp, opts, l.nextTablePaths)
+	if err != nil {
+		l.finishCompaction(info, start, tables, nil, err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrMergingSSTables, err)
+	}
+
+	previousLevels := l.copyLevels()
+	for level := range l.levels {
+		kept := make([]run, 0, len(l.levels[level]))
+		for i, r := range l.levels[level] {
+			if !slices.Contains(positions, runPosition{level: level, idx: i}) {
+				kept = append(kept, r)
+			}
+		}
+		l.levels[level] = kept
+	}
+	if len(newRun) > 0 {
+		l.levels[bottomLevel] = append(l.levels[bottomLevel], newRun)
+	}
+
+	err = l.writeManifest()
+	if err != nil {
+		l.levels = previousLevels
+		closeTables(newRun)
+		err = fmt.Errorf("%w: %w", ErrWritingManifest, err)
+		l.finishCompaction(info, start, tables, nil, err)
+		return err
+	}
+
+	l.finishCompaction(info, start, tables, newRun, nil)
+	l.removeTables(tables)
+	l.listener.tablesDeleted(info.Inputs)
+	if len(newRun) == 0 {
+		return nil
+	}
+
+	err = l.mergeSSTables()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMergingSSTables, err)
+	}
+
+	return nil
+}
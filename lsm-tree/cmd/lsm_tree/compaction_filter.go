@@ -0,0 +1,29 @@
+package lsm_tree
+
+import "hw1/internal/sstable"
+
+type FilterDecision = sstable.FilterDecision
+
+const (
+	FilterKeep        = sstable.FilterKeep
+	FilterRemove      = sstable.FilterRemove
+	FilterChangeValue = sstable.FilterChangeValue
+)
+
+// CompactionFilter garbage-collects data without explicit deletes, e.g. keys
+// of deleted tenants. Merges call it for every element that survives them,
+// with the level the merge writes to. A removed key reads as deleted, a
+// changed value replaces the old one. Parallel subcompactions call it
+// concurrently.
+type CompactionFilter interface {
+	Filter(level int, key string, value string) (FilterDecision, string)
+}
+
+func (l *LSMTree) mergeFilter(level int) func(key string, value string) (FilterDecision, string) {
+	if l.compactionFilter == nil {
+		return nil
+	}
+	return func(key string, value string) (FilterDecision, string) {
+		return l.compactionFilter.Filter(level, key, value)
+	}
+}
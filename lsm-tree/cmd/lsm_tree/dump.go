@@ -0,0 +1,282 @@
+package lsm_tree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"hw1/internal/sstable"
+)
+
+// A dump is a sequence of frames: a header with the format version and the
+// comparator name, a record per live key in the comparator order and an end
+// frame with the number of records, which detects truncated dumps. Every
+// frame is {type uint8, payload length uint32, payload, CRC-32C uint32} with
+// the checksum covering everything before it. A record is:
+//   - version 1: the key;
+//   - version 2: the expiry time as an int64 and the key;
+//   - version 3: the expiry time, the key length as a uint32, the key and the
+//     value.
+const (
+	dumpMagic   uint64 = 0x504d554454534d4c
+	dumpVersion uint32 = 3
+
+	frameHeader  uint8 = 1
+	frameRecord  uint8 = 2
+	frameEnd     uint8 = 3
+	maxFrameSize       = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Export writes every live key to w in the dump format. Writes wait until
+// the export ends.
+func (l *LSMTree) Export(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+
+	if err := l.export(w); err != nil {
+		return fmt.Errorf("%w: %w", ErrExporting, err)
+	}
+	return nil
+}
+
+func (l *LSMTree) export(w io.Writer) error {
+	sources, err := l.rangeSources("", "", SearchRangeOptions{LeftBound: Unbounded, RightBound: Unbounded})
+	if err != nil {
+		return err
+	}
+	inputs := make([]sstable.ElementIterator, len(sources))
+	for i, source := range sources {
+		inputs[len(sources)-1-i] = source.it
+	}
+	it := sstable.NewMergingIterator(inputs, l.cmp, false)
+	now := l.now().UnixNano()
+	it.FoldOperands(l.mergeOperator, now, true)
+
+	bw := bufio.NewWriter(w)
+	if err = binary.Write(bw, binary.LittleEndian, dumpMagic); err != nil {
+		return err
+	}
+
+	header := new(bytes.Buffer)
+	_ = binary.Write(header, binary.LittleEndian, dumpVersion)
+	header.WriteString(l.cmp.Name())
+	if err = writeFrame(bw, frameHeader, header.Bytes()); err != nil {
+		return err
+	}
+
+	var records uint64
+	for {
+		element, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if element.IsTombstone || element.Expired(now) {
+			continue
+		}
+
+		if err = writeFrame(bw, frameRecord, encodeRecord(element)); err != nil {
+			return err
+		}
+		records++
+	}
+
+	if err = writeFrame(bw, frameEnd, binary.LittleEndian.AppendUint64(nil, records)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import adds every key of a dump written by Export, as if the keys were
+// added after everything already in the tree. The keys are bulk-loaded into
+// new tables that are ingested at once, so nothing is added if the dump turns
+// out to be corrupted.
+func (l *LSMTree) Import(r io.Reader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	imported, err := l.importTables(r)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrImporting, err)
+	}
+	if len(imported) == 0 {
+		return nil
+	}
+	return l.ingestRuns([]run{imported})
+}
+
+func (l *LSMTree) importTables(r io.Reader) (run, error) {
+	br := bufio.NewReader(r)
+	var magic uint64
+	if err := binary.Read(br, binary.LittleEndian, &magic); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDump, err)
+	}
+	if magic != dumpMagic {
+		return nil, fmt.Errorf("%w: not a dump", ErrInvalidDump)
+	}
+
+	frameType, payload, err := readFrame(br)
+	if err != nil {
+		return nil, err
+	}
+	if frameType != frameHeader || len(payload) < 4 {
+		return nil, fmt.Errorf("%w: no header", ErrInvalidDump)
+	}
+	version := binary.LittleEndian.Uint32(payload)
+	if version > dumpVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidDump, version)
+	}
+	if name := string(payload[4:]); name != l.cmp.Name() {
+		return nil, fmt.Errorf("%w: dump uses comparator %q, got %q", ErrInvalidDump, name, l.cmp.Name())
+	}
+
+	imported := make(run, 0)
+	var writer *sstable.SSTWriter
+	var metaPath, dataPath string
+	fail := func(err error) (run, error) {
+		if writer != nil {
+			writer.Abort()
+		}
+		l.removeTables(imported)
+		return nil, err
+	}
+	finishTable := func() error {
+		w := writer
+		writer = nil
+		if err := w.Finish(); err != nil {
+			return err
+		}
+		table, err := sstable.Open(l.fs, metaPath, dataPath, l.cmp)
+		if err != nil {
+			return err
+		}
+		imported = append(imported, table)
+		return nil
+	}
+
+	var records uint64
+	for {
+		frameType, payload, err := readFrame(br)
+		if err != nil {
+			return fail(err)
+		}
+
+		if frameType == frameEnd {
+			if len(payload) != 8 || binary.LittleEndian.Uint64(payload) != records {
+				return fail(fmt.Errorf("%w: record count mismatch", ErrInvalidDump))
+			}
+			break
+		}
+		if frameType != frameRecord {
+			return fail(fmt.Errorf("%w: unexpected frame type %d", ErrInvalidDump, frameType))
+		}
+
+		if writer == nil {
+			metaPath, dataPath = l.nextTablePaths()
+			writer, err = sstable.NewSSTWriter(l.fs, metaPath, dataPath, l.cmp)
+			if err != nil {
+				return fail(err)
+			}
+		}
+		element, err := decodeRecord(version, payload)
+		if err != nil {
+			return fail(err)
+		}
+		if err = writer.AddElement(element); err != nil {
+			return fail(err)
+		}
+		records++
+
+		if writer.FileSize() >= l.targetFileSize {
+			if err = finishTable(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	if writer != nil {
+		if err := finishTable(); err != nil {
+			return fail(err)
+		}
+	}
+	return imported, nil
+}
+
+func encodeRecord(element *sstable.TableElement) []byte {
+	record := make([]byte, 0, 12+len(element.Value)+len(element.Data))
+	record = binary.LittleEndian.AppendUint64(record, uint64(element.ExpiresAt))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(element.Value)))
+	record = append(record, element.Value...)
+	return append(record, element.Data...)
+}
+
+func decodeRecord(version uint32, payload []byte) (sstable.TableElement, error) {
+	if version < 2 {
+		return sstable.TableElement{Value: string(payload)}, nil
+	}
+
+	if len(payload) < 8 {
+		return sstable.TableElement{}, fmt.Errorf("%w: short record", ErrInvalidDump)
+	}
+	element := sstable.TableElement{ExpiresAt: int64(binary.LittleEndian.Uint64(payload))}
+	payload = payload[8:]
+	if version < 3 {
+		element.Value = string(payload)
+		return element, nil
+	}
+
+	if len(payload) < 4 || uint64(binary.LittleEndian.Uint32(payload)) > uint64(len(payload)-4) {
+		return sstable.TableElement{}, fmt.Errorf("%w: short record", ErrInvalidDump)
+	}
+	keyLength := binary.LittleEndian.Uint32(payload)
+	element.Value = string(payload[4 : 4+keyLength])
+	element.Data = string(payload[4+keyLength:])
+	return element, nil
+}
+
+func writeFrame(w io.Writer, frameType uint8, payload []byte) error {
+	frame := make([]byte, 0, 9+len(payload))
+	frame = append(frame, frameType)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.Checksum(frame, crcTable))
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (uint8, []byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrInvalidDump, err)
+	}
+	length := binary.LittleEndian.Uint32(prefix[1:])
+	if length > maxFrameSize {
+		return 0, nil, fmt.Errorf("%w: frame of %d bytes", ErrInvalidDump, length)
+	}
+
+	rest := make([]byte, length+4)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrInvalidDump, err)
+	}
+	payload := rest[:length]
+
+	checksum := crc32.Update(crc32.Checksum(prefix[:], crcTable), crcTable, payload)
+	if checksum != binary.LittleEndian.Uint32(rest[length:]) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidDump)
+	}
+	return prefix[0], payload, nil
+}
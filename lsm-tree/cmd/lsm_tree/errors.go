@@ -1,11 +1,31 @@
 package lsm_tree
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
+	ErrCheckpoint           = errors.New("error creating checkpoint")
+	ErrClosed               = errors.New("lsm tree is closed")
+	ErrClosingSSTable       = errors.New("error closing sstable")
+	ErrCorruptedManifest    = errors.New("manifest is corrupted")
 	ErrCreatingSSTable      = errors.New("error creating sstable")
+	ErrExporting            = errors.New("error exporting lsm tree")
 	ErrFlushingRAMComponent = errors.New("error flushing lsm tree RAM component")
+	ErrImporting            = errors.New("error importing lsm tree")
+	ErrIngesting            = errors.New("error ingesting sstable")
+	ErrInvalidDump          = errors.New("dump is corrupted or incompatible")
+	ErrInvalidTTL           = errors.New("ttl must be positive")
+	ErrInvalidRange         = errors.New("invalid key range")
+	ErrMerging              = errors.New("error merging value")
 	ErrMergingSSTables      = errors.New("error merging sstables")
+	ErrOpening              = errors.New("error opening lsm tree")
+	ErrReadOnly             = errors.New("lsm tree is read-only")
 	ErrRemovingSSTable      = errors.New("error removing sstable")
+	ErrRepairing            = errors.New("error repairing lsm tree")
 	ErrSearching            = errors.New("error searching sstable")
+	ErrWritingManifest      = errors.New("error writing manifest")
+
+	errOpenedReadOnly = fmt.Errorf("%w: opened with Options.ReadOnly", ErrReadOnly)
 )
@@ -0,0 +1,163 @@
+package lsm_tree
+
+import (
+	"time"
+
+	"hw1/internal/sstable"
+)
+
+// EventListener is a set of optional callbacks for the internal events of
+// the tree. They run synchronously under the tree lock, so they must not
+// call the tree and should return quickly.
+type EventListener struct {
+	OnFlushBegin      func(FlushInfo)
+	OnFlushEnd        func(FlushInfo)
+	OnCompactionBegin func(CompactionInfo)
+	OnCompactionEnd   func(CompactionInfo)
+	// A table is created once the manifest references it and deleted once
+	// the manifest stops referencing it.
+	OnTableCreated func(TableInfo)
+	OnTableDeleted func(TableInfo)
+	// OnWriteStall is called after a write waited for the flush of the full
+	// memtable and the merges it triggered.
+	OnWriteStall      func(WriteStallInfo)
+	OnBackgroundError func(error)
+}
+
+type TableInfo struct {
+	Level    int
+	MetaPath string
+	DataPath string
+	Smallest string
+	Largest  string
+	Elements int
+	FileSize int64
+}
+
+// Table and Duration are set only for OnFlushEnd; Err is set if the flush
+// failed.
+type FlushInfo struct {
+	Keys     int
+	Table    TableInfo
+	Duration time.Duration
+	Err      error
+}
+
+// Level is the level the compaction writes to, merges of full levels
+// included. Outputs, the byte counts and Duration are set only for
+// OnCompactionEnd; Err is set if the compaction failed.
+type CompactionInfo struct {
+	Level        int
+	Inputs       []TableInfo
+	Outputs      []TableInfo
+	BytesRead    int64
+	BytesWritten int64
+	Duration     time.Duration
+	Err          error
+}
+
+type WriteStallInfo struct {
+	Duration time.Duration
+}
+
+func tableInfo(level int, table *sstable.SSTable) TableInfo {
+	return TableInfo{
+		Level:    level,
+		MetaPath: table.MetaPath(),
+		DataPath: table.DataPath(),
+		Smallest: table.Smallest(),
+		Largest:  table.Largest(),
+		Elements: table.Size(),
+		FileSize: table.FileSize(),
+	}
+}
+
+func tableInfos(level int, tables []*sstable.SSTable) []TableInfo {
+	infos := make([]TableInfo, len(tables))
+	for i, table := range tables {
+		infos[i] = tableInfo(level, table)
+	}
+	return infos
+}
+
+func (e *EventListener) flushBegin(info FlushInfo) {
+	if e.OnFlushBegin != nil {
+		e.OnFlushBegin(info)
+	}
+}
+
+func (e *EventListener) flushEnd(info FlushInfo) {
+	if e.OnFlushEnd != nil {
+		e.OnFlushEnd(info)
+	}
+}
+
+func (e *EventListener) compactionBegin(info CompactionInfo) {
+	if e.OnCompactionBegin != nil {
+		e.OnCompactionBegin(info)
+	}
+}
+
+func (e *EventListener) compactionEnd(info CompactionInfo) {
+	if e.OnCompactionEnd != nil {
+		e.OnCompactionEnd(info)
+	}
+}
+
+func (e *EventListener) tablesCreated(infos []TableInfo) {
+	if e.OnTableCreated != nil {
+		for _, info := range infos {
+			e.OnTableCreated(info)
+		}
+	}
+}
+
+func (e *EventListener) tablesDeleted(infos []TableInfo) {
+	if e.OnTableDeleted != nil {
+		for _, info := range infos {
+			e.OnTableDeleted(info)
+		}
+	}
+}
+
+func (e *EventListener) writeStall(info WriteStallInfo) {
+	if e.OnWriteStall != nil {
+		e.OnWriteStall(info)
+	}
+}
+
+func (e *EventListener) backgroundError(err error) {
+	if e.OnBackgroundError != nil {
+		e.OnBackgroundError(err)
+	}
+}
+
+// finishCompaction reports a compaction started at start to the metrics and
+// the listener. Outputs are nil if it failed.
+func (l *LSMTree) finishCompaction(info CompactionInfo, start time.Time, inputs []*sstable.SSTable, outputs []*sstable.SSTable, err error) {
+	info.Duration = time.Since(start)
+	info.Err = err
+	if err == nil {
+		l.stats.recordCompaction(start, inputs, outputs)
+		info.Outputs = tableInfos(info.Level, outputs)
+		info.BytesRead = tablesBytes(inputs)
+		info.BytesWritten = tablesBytes(outputs)
+		l.logger.Info("compaction finished",
+			"level", info.Level,
+			"inputs", len(info.Inputs),
+			"outputs", len(info.Outputs),
+			"bytes_read", info.BytesRead,
+			"bytes_written", info.BytesWritten,
+			"duration", info.Duration)
+	} else {
+		l.logger.Error("compaction failed",
+			"level", info.Level,
+			"inputs", len(info.Inputs),
+			"duration", info.Duration,
+			"error", err)
+	}
+	l.listener.compactionEnd(info)
+	if err == nil {
+		l.listener.tablesCreated(info.Outputs)
+	}
+}
@@ -0,0 +1,164 @@
+package lsm_tree
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+type TablePaths struct {
+	Meta string
+	Data string
+}
+
+// Ingest adds tables built by sstable.SSTWriter without rewriting them: the
+// files are hard-linked into the tree, so the originals may be removed
+// afterwards. Later tables in paths are newer than earlier ones and all of
+// them are newer than the data already in the tree. Every table is placed
+// into the lowest level that keeps it below no newer overlapping data.
+func (l *LSMTree) Ingest(paths []TablePaths) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	tables := make([]*sstable.SSTable, 0, len(paths))
+	for _, p := range paths {
+		table, err := l.linkTable(p)
+		if err != nil {
+			l.removeTables(tables)
+			return fmt.Errorf("%w: %w", ErrIngesting, err)
+		}
+		if table.Size() == 0 {
+			l.removeTables([]*sstable.SSTable{table})
+			continue
+		}
+		tables = append(tables, table)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	runs := make([]run, len(tables))
+	for i, table := range tables {
+		runs[i] = run{table}
+	}
+	return l.ingestRuns(runs)
+}
+
+// ingestRuns places runs ordered from the oldest to the newest above all the
+// data in the tree. The runs are removed if they can't be placed.
+func (l *LSMTree) ingestRuns(runs []run) error {
+	tables := make([]*sstable.SSTable, 0)
+	for _, r := range runs {
+		tables = append(tables, r...)
+	}
+
+	if l.ramComponentOverlaps(runs) {
+		err := l.flushRAMComponent()
+		if err != nil {
+			l.removeTables(tables)
+			l.setBackgroundError(err)
+			return fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+		}
+	}
+
+	previousLevels := l.copyLevels()
+	created := make([]TableInfo, 0, len(tables))
+	for _, r := range runs {
+		level := l.ingestLevel(r)
+		l.levels[level] = append(l.levels[level], r)
+		created = append(created, tableInfos(level, r)...)
+	}
+
+	err := l.writeManifest()
+	if err != nil {
+		l.levels = previousLevels
+		closeTables(tables)
+		l.setBackgroundError(err)
+		return fmt.Errorf("%w: %w", ErrWritingManifest, err)
+	}
+	l.listener.tablesCreated(created)
+
+	err = l.mergeSSTables()
+	if err != nil {
+		l.setBackgroundError(err)
+		return fmt.Errorf("%w: %w", ErrMergingSSTables, err)
+	}
+
+	return nil
+}
+
+// linkTable links the files under the next file number, which orders the
+// table after everything written before, and opens it, which validates it.
+func (l *LSMTree) linkTable(p TablePaths) (*sstable.SSTable, error) {
+	metaPath, dataPath := l.nextTablePaths()
+	removeLinks := func() {
+		_ = l.fs.Remove(metaPath)
+		_ = l.fs.Remove(dataPath)
+	}
+
+	for _, link := range [][2]string{{p.Meta, metaPath}, {p.Data, dataPath}} {
+		if err := l.fs.MkdirAll(filepath.Dir(link[1]), 0770); err != nil {
+			removeLinks()
+			return nil, err
+		}
+		if err := vfs.LinkOrCopy(l.fs, link[0], link[1]); err != nil {
+			removeLinks()
+			return nil, err
+		}
+		if err := l.fs.SyncDir(filepath.Dir(link[1])); err != nil {
+			removeLinks()
+			return nil, err
+		}
+	}
+
+	table, err := sstable.Open(l.fs, metaPath, dataPath, l.cmp)
+	if err != nil {
+		removeLinks()
+		return nil, err
+	}
+	return table, nil
+}
+
+// ramComponentOverlaps reports if the RAM component must be flushed first,
+// since the ingested runs must end up newer than it.
+func (l *LSMTree) ramComponentOverlaps(runs []run) bool {
+	overlaps := func(key string) bool {
+		for _, r := range runs {
+			if l.cmp.Compare(key, r.smallest()) >= 0 && l.cmp.Compare(key, r.largest()) <= 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for key := range l.ramComponent {
+		if overlaps(key) {
+			return true
+		}
+	}
+	for key := range l.ramComponentRemoved {
+		if overlaps(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestLevel returns the first level with a run overlapping the ingested
+// one, as it can be the newest run there but can't go any deeper. Without
+// overlaps it goes to the bottom level.
+func (l *LSMTree) ingestLevel(ingested run) int {
+	for level := range l.levels {
+		for _, r := range l.levels[level] {
+			if l.cmp.Compare(r.largest(), ingested.smallest()) >= 0 && l.cmp.Compare(r.smallest(), ingested.largest()) <= 0 {
+				return level
+			}
+		}
+	}
+	return len(l.levels) - 1
+}
@@ -1,147 +1,568 @@
 package lsm_tree
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"path/filepath"
-	"sort"
-	"strconv"
+	"time"
 
 	"hw1/internal/common"
 	"hw1/internal/sstable"
+	"hw1/internal/vfs"
 )
 
 type LSMTree struct {
-	sstables            [][]*sstable.SSTable
-	ramComponent        map[string]struct{}
+	mu                  mutex
+	closed              bool
+	backgroundErr       error
+	levels              [][]run
+	ramComponent        map[string]sstable.TableElement
 	ramComponentRemoved map[string]struct{}
 	fileCnt             int
+	cmp                 Comparator
+	fs                  vfs.FS
+	dir                 string
+	targetFileSize      int64
+	maxSubcompactions   int
+	now                 func() time.Time
+	compactionFilter    CompactionFilter
+	mergeOperator       MergeOperator
+	listener            EventListener
+	logger              *slog.Logger
+	tracer              Tracer
+	stats               *stats
+	// Tables removed while checkpoints link their files are removed when the
+	// last checkpoint is done.
+	checkpoints    int
+	pendingRemoval []*sstable.SSTable
+	readOnly       bool
 }
 
 func New() *LSMTree {
+	return NewWithOptions(Options{})
+}
+
+func NewWithOptions(opts Options) *LSMTree {
+	opts = opts.withDefaults()
+
 	return &LSMTree{
-		ramComponent:        make(map[string]struct{}),
+		mu:                  newMutex(),
+		ramComponent:        make(map[string]sstable.TableElement),
 		ramComponentRemoved: make(map[string]struct{}),
-		sstables:            make([][]*sstable.SSTable, 1),
+		levels:              make([][]run, 1),
+		cmp:                 opts.Comparator,
+		fs:                  opts.FS,
+		dir:                 opts.Dir,
+		targetFileSize:      opts.TargetFileSize,
+		maxSubcompactions:   opts.MaxSubcompactions,
+		now:                 opts.Now,
+		compactionFilter:    opts.CompactionFilter,
+		mergeOperator:       opts.MergeOperator,
+		listener:            opts.EventListener,
+		logger:              opts.Logger,
+		tracer:              opts.Tracer,
+		stats:               newStats(),
+		readOnly:            opts.ReadOnly,
 	}
 }
 
-func (l *LSMTree) Add(s string) error {
-	l.ramComponent[s] = struct{}{}
-	delete(l.ramComponentRemoved, s)
+// Open restores the tree described by the manifest in opts.Dir. Without a
+// manifest, the tables already in the directory are adopted, so a tree
+// written before the manifest existed keeps its data, and an empty directory
+// starts an empty tree. Files left by an interrupted flush or merge are
+// removed, but other files are only removed with a manifest to tell them
+// apart from live tables. A read-only tree adopts the tables only in memory
+// and removes nothing.
+func Open(opts Options) (*LSMTree, error) {
+	start := time.Now()
+	l := NewWithOptions(opts)
 
-	if len(l.ramComponent)+len(l.ramComponentRemoved) == common.FirstLevelSize {
-		err := l.flushRAMComponent()
-		if err != nil {
-			return fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+	if !l.readOnly {
+		if err := l.fs.MkdirAll(l.dir, 0770); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrOpening, err)
 		}
 	}
 
-	return nil
+	m, err := readManifest(l.fs, l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOpening, err)
+	}
+
+	if m != nil {
+		if m.Comparator != l.cmp.Name() {
+			return nil, fmt.Errorf("%w: %w", ErrOpening, sstable.ErrComparatorMismatch)
+		}
+
+		l.fileCnt = m.FileCnt
+		l.levels = make([][]run, max(len(m.Levels), 1))
+		for level, runs := range m.Levels {
+			for _, numbers := range runs {
+				r := make(run, 0, len(numbers))
+				for _, number := range numbers {
+					metaPath, dataPath := l.tablePaths(number)
+					table, err := sstable.Open(l.fs, metaPath, dataPath, l.cmp)
+					if err != nil {
+						l.closeTables()
+						return nil, fmt.Errorf("%w: %w", ErrOpening, err)
+					}
+					r = append(r, table)
+				}
+				l.levels[level] = append(l.levels[level], r)
+			}
+		}
+		if !l.readOnly {
+			err = l.removeObsoleteFiles()
+		}
+	} else {
+		err = l.adoptTables()
+		if err == nil && !l.readOnly {
+			err = l.removeTempFiles()
+		}
+	}
+	if err != nil {
+		l.closeTables()
+		return nil, fmt.Errorf("%w: %w", ErrOpening, err)
+	}
+
+	l.logger.Info("opened lsm tree",
+		"dir", l.dir,
+		"recovered", m != nil,
+		"levels", len(l.levels),
+		"tables", len(l.allTables()),
+		"duration", time.Since(start))
+
+	return l, nil
+}
+
+func (l *LSMTree) Add(s string) error {
+	return l.AddContext(context.Background(), s)
+}
+
+// AddContext is Add that gives up waiting for the tree lock when ctx is
+// done. A write that fills the memtable waits for its flush regardless.
+func (l *LSMTree) AddContext(ctx context.Context, s string) error {
+	return l.write(ctx, newTrace(OperationAdd, s), func() error {
+		return l.add(sstable.TableElement{Value: s})
+	})
+}
+
+// Put adds the key with a value; Add stores keys with empty values.
+func (l *LSMTree) Put(key string, value string) error {
+	return l.PutContext(context.Background(), key, value)
+}
+
+func (l *LSMTree) PutContext(ctx context.Context, key string, value string) error {
+	return l.write(ctx, newTrace(OperationPut, key), func() error {
+		return l.add(sstable.TableElement{Value: key, Data: value})
+	})
+}
+
+// AddWithTTL adds a key that reads as absent once ttl passes and is dropped
+// by merges after that.
+func (l *LSMTree) AddWithTTL(s string, ttl time.Duration) error {
+	return l.AddWithTTLContext(context.Background(), s, ttl)
+}
+
+func (l *LSMTree) AddWithTTLContext(ctx context.Context, s string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return l.write(ctx, newTrace(OperationAddWithTTL, s), func() error {
+		return l.add(sstable.TableElement{Value: s, ExpiresAt: l.now().Add(ttl).UnixNano()})
+	})
+}
+
+func (l *LSMTree) add(element sstable.TableElement) error {
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	l.insert(element)
+	return l.flushFullRAMComponent()
+}
+
+func (l *LSMTree) insert(element sstable.TableElement) {
+	l.ramComponent[element.Value] = element
+	delete(l.ramComponentRemoved, element.Value)
 }
 
 func (l *LSMTree) Delete(s string) error {
+	return l.DeleteContext(context.Background(), s)
+}
+
+func (l *LSMTree) DeleteContext(ctx context.Context, s string) error {
+	return l.write(ctx, newTrace(OperationDelete, s), func() error {
+		return l.remove(s)
+	})
+}
+
+func (l *LSMTree) remove(s string) error {
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	l.markRemoved(s)
+	return l.flushFullRAMComponent()
+}
+
+func (l *LSMTree) markRemoved(s string) {
 	l.ramComponentRemoved[s] = struct{}{}
 	delete(l.ramComponent, s)
+}
 
-	if len(l.ramComponent)+len(l.ramComponentRemoved) == common.FirstLevelSize {
-		err := l.flushRAMComponent()
-		if err != nil {
-			return fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
-		}
+// write runs apply under the tree lock unless ctx is done first.
+func (l *LSMTree) write(ctx context.Context, trace *OperationTrace, apply func() error) (err error) {
+	if err = l.mu.LockContext(ctx); err != nil {
+		return err
 	}
+	defer l.mu.Unlock()
+	defer func() { l.finishTrace(trace, err) }()
 
-	return nil
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return apply()
 }
 
 func (l *LSMTree) SearchKey(s string) (bool, error) {
-	if _, ok := l.ramComponent[s]; ok {
-		return true, nil
+	return l.SearchKeyContext(context.Background(), s)
+}
+
+func (l *LSMTree) SearchKeyContext(ctx context.Context, s string) (bool, error) {
+	element, err := l.read(ctx, newTrace(OperationSearchKey, s))
+	return element != nil, err
+}
+
+// Get returns the value of the key and whether the key is present.
+func (l *LSMTree) Get(key string) (string, bool, error) {
+	return l.GetContext(context.Background(), key)
+}
+
+func (l *LSMTree) GetContext(ctx context.Context, key string) (string, bool, error) {
+	element, err := l.read(ctx, newTrace(OperationGet, key))
+	if err != nil || element == nil {
+		return "", false, err
+	}
+	return element.Data, true, nil
+}
+
+// read looks the key of the trace up under the tree lock.
+func (l *LSMTree) read(ctx context.Context, trace *OperationTrace) (element *sstable.TableElement, err error) {
+	if err = l.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	defer l.stats.recordRead(time.Now())
+	defer func() { l.finishTrace(trace, err) }()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &sstable.ReadStats{}
+	element, err = l.get(trace.Key, stats)
+	trace.TablesProbed = stats.TablesConsidered - stats.TablesSkippedByRange
+	trace.BloomFilterSkips = stats.BloomNegatives
+	trace.RecordsRead = stats.Probes
+	l.stats.bloomFilterUseful += int64(stats.BloomNegatives)
+	l.stats.bloomFilterFalsePositive += int64(stats.BloomFalsePositives)
+	if ctxStats := readStatsFrom(ctx); ctxStats != nil {
+		ctxStats.Add(*stats)
+	}
+	return element, err
+}
+
+// get returns nil if the key is absent, deleted or expired. Merge operands
+// are collected down to the first other version of the key and folded over
+// it.
+func (l *LSMTree) get(s string, stats *sstable.ReadStats) (*sstable.TableElement, error) {
+	now := l.now().UnixNano()
+	operands := make([]string, 0)
+	resolve := func(base *sstable.TableElement) (*sstable.TableElement, error) {
+		if len(operands) == 0 {
+			if base == nil || base.IsTombstone || base.Expired(now) {
+				return nil, nil
+			}
+			return base, nil
+		}
+
+		element, err := sstable.ResolveOperands(l.mergeOperator, s, operands, base, now, true)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMerging, err)
+		}
+		return element, nil
+	}
+
+	if element, ok := l.ramComponent[s]; ok {
+		if !element.IsMergeOperand {
+			return resolve(&element)
+		}
+		operands = append(operands, element.Data)
 	}
 	if _, ok := l.ramComponentRemoved[s]; ok {
-		return false, nil
+		return resolve(&sstable.TableElement{Value: s, IsTombstone: true})
 	}
 
-	for level := range len(l.sstables) {
-		for i := len(l.sstables[level]) - 1; i >= 0; i-- {
-			searchResult, err := l.sstables[level][i].SearchKey(s)
+	for level := range len(l.levels) {
+		for i := len(l.levels[level]) - 1; i >= 0; i-- {
+			// Tables of a run are disjoint, so at most one covers the key.
+			table := l.levels[level][i].tableFor(s, l.cmp)
+			if table == nil {
+				stats.TablesConsidered++
+				stats.TablesSkippedByRange++
+				continue
+			}
+
+			element, err := table.GetWithStats(s, stats)
 			if err != nil {
-				return false, fmt.Errorf("%w: %w", ErrSearching, err)
+				return nil, fmt.Errorf("%w: %w", ErrSearching, err)
 			}
-			if searchResult == sstable.SearchResultFound {
-				return true, nil
+			if element == nil {
+				continue
 			}
-			if searchResult == sstable.SearchResultRemoved {
-				return false, nil
+			if !element.IsMergeOperand {
+				return resolve(element)
 			}
+			operands = append(operands, element.Data)
 		}
 	}
 
-	return false, nil
+	return resolve(nil)
 }
 
 func (l *LSMTree) SearchRange(keyL string, keyR string) ([]string, error) {
-	if keyL > keyR {
-		return nil, fmt.Errorf("invalid key range")
+	res, err := l.SearchRangeWithOptions(keyL, keyR, SearchRangeOptions{})
+	if err != nil {
+		return nil, err
 	}
+	return res.Keys, nil
+}
 
-	resElements := make([]*sstable.TableElement, 0)
-	for key := range l.ramComponent {
-		if key >= keyL && key <= keyR {
-			resElements = append(resElements, &sstable.TableElement{Value: key})
+// Clear removes every key, both from the RAM component and from the disk.
+func (l *LSMTree) Clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.readOnly {
+		return errOpenedReadOnly
+	}
+
+	l.ramComponent = make(map[string]sstable.TableElement)
+	l.ramComponentRemoved = make(map[string]struct{})
+	for level, runs := range l.levels {
+		for _, r := range runs {
+			l.removeTables(r)
+			l.listener.tablesDeleted(tableInfos(level, r))
 		}
 	}
-	for key := range l.ramComponentRemoved {
-		if key >= keyL && key <= keyR {
-			resElements = append(resElements, &sstable.TableElement{Value: key, IsTombstone: true})
+	l.levels = make([][]run, 1)
+
+	err := l.fs.Remove(filepath.Join(l.dir, common.ManifestFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		l.logger.Warn("removing manifest failed", "dir", l.dir, "error", err)
+	}
+	return nil
+}
+
+func (l *LSMTree) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+
+	if len(l.ramComponent)+len(l.ramComponentRemoved) == 0 {
+		return nil
+	}
+
+	err := l.flushRAMComponent()
+	if err != nil {
+		l.setBackgroundError(err)
+		return fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+	}
+
+	return nil
+}
+
+// Close flushes the RAM component and syncs and closes every sstable. It waits
+// for the running operations, since they hold the tree lock. A tree in the
+// read-only mode after a background error still holds the writes accepted
+// before it, so the flush is retried: failed flushes and merges are rolled
+// back, and the new table only adds to the manifest. The tree is closed even
+// if the flush or some of the tables fail, and all the errors are returned,
+// the keys the RAM component loses included.
+func (l *LSMTree) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+
+	var errs []error
+	if keys := len(l.ramComponent) + len(l.ramComponentRemoved); keys > 0 {
+		err := l.flushRAMComponent()
+		if err != nil {
+			l.setBackgroundError(err)
+			errs = append(errs, fmt.Errorf("%w: %d keys are lost: %w", ErrFlushingRAMComponent, keys, err))
 		}
 	}
 
-	for level := range len(l.sstables) {
-		for i := len(l.sstables[level]) - 1; i >= 0; i-- {
-			searchResult, err := l.sstables[level][i].SearchRange(keyL, keyR)
-			if err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrSearching, err)
+	for _, sst := range l.allTables() {
+		if !l.readOnly {
+			if err := sst.Sync(); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %w", ErrClosingSSTable, err))
 			}
-			resElements = append(resElements, searchResult...)
+		}
+		if err := sst.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrClosingSSTable, err))
 		}
 	}
+	l.closed = true
 
-	sort.SliceStable(resElements, func(i, j int) bool {
-		return resElements[i].Value < resElements[j].Value
-	})
+	return errors.Join(errs...)
+}
 
-	res := make([]string, 0)
-	for i, element := range resElements {
-		if !element.IsTombstone && (i == 0 || resElements[i-1].Value != element.Value) {
-			res = append(res, element.Value)
-		}
+// Err returns the error that switched the tree to the read-only mode. A failed
+// flush or merge is rolled back, but the on-disk state it left is resolved
+// only by the next Open, so no writes are accepted until then.
+func (l *LSMTree) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.backgroundErr
+}
+
+func (l *LSMTree) checkWritable() error {
+	if l.closed {
+		return ErrClosed
+	}
+	if l.readOnly {
+		return errOpenedReadOnly
+	}
+	if l.backgroundErr != nil {
+		return fmt.Errorf("%w after a background error: %w", ErrReadOnly, l.backgroundErr)
 	}
+	return nil
+}
 
-	return res, nil
+func (l *LSMTree) setBackgroundError(err error) {
+	if l.backgroundErr == nil {
+		l.backgroundErr = err
+		l.logger.Error("lsm tree switched to read-only mode", "error", err)
+		l.listener.backgroundError(err)
+	}
 }
 
-func (l *LSMTree) Clear() {
-	for level := range l.sstables {
-		for _, sst := range l.sstables[level] {
-			_ = sst.Remove()
+// removeTables deletes the files of tables the manifest no longer references.
+// A failure leaves garbage but not an inconsistency: Open collects it.
+func (l *LSMTree) removeTables(tables []*sstable.SSTable) {
+	if l.checkpoints > 0 {
+		l.pendingRemoval = append(l.pendingRemoval, tables...)
+		return
+	}
+
+	for _, table := range tables {
+		if err := table.Remove(); err != nil {
+			l.logger.Warn("removing table failed", "meta", table.MetaPath(), "data", table.DataPath(), "error", err)
+			continue
 		}
+		l.logger.Debug("removed table", "meta", table.MetaPath(), "data", table.DataPath())
+	}
+}
+
+func (l *LSMTree) closeTables() {
+	closeTables(l.allTables())
+}
+
+func closeTables(tables []*sstable.SSTable) {
+	for _, table := range tables {
+		_ = table.Close()
+	}
+}
+
+func (l *LSMTree) nextTablePaths() (string, string) {
+	metaPath, dataPath := l.tablePaths(l.fileCnt)
+	l.fileCnt++
+	return metaPath, dataPath
+}
+
+// flushFullRAMComponent flushes the RAM component once it is full, or more
+// than full after a batch. The write that filled it waits for the flush,
+// which is reported as a write stall.
+func (l *LSMTree) flushFullRAMComponent() error {
+	if len(l.ramComponent)+len(l.ramComponentRemoved) < common.FirstLevelSize {
+		return nil
 	}
+
+	start := time.Now()
+	err := l.flushRAMComponent()
+	l.logger.Debug("write stalled on memtable flush", "duration", time.Since(start))
+	l.listener.writeStall(WriteStallInfo{Duration: time.Since(start)})
+	if err != nil {
+		l.setBackgroundError(err)
+		return fmt.Errorf("%w: %w", ErrFlushingRAMComponent, err)
+	}
+
+	return nil
 }
 
+// flushRAMComponent keeps the RAM component and the in-memory levels intact
+// if the flush fails.
 func (l *LSMTree) flushRAMComponent() error {
+	info := FlushInfo{Keys: len(l.ramComponent) + len(l.ramComponentRemoved)}
+	l.listener.flushBegin(info)
+	start := time.Now()
+	failed := func(err error) error {
+		info.Duration = time.Since(start)
+		info.Err = err
+		l.logger.Error("flush failed", "keys", info.Keys, "duration", info.Duration, "error", err)
+		l.listener.flushEnd(info)
+		return err
+	}
+
+	metaPath, dataPath := l.nextTablePaths()
 	newSSTable, err := sstable.NewFromMap(
-		filepath.Join(common.MetaDataDir, strconv.Itoa(l.fileCnt)),
-		filepath.Join(common.DataDir, strconv.Itoa(l.fileCnt)),
+		l.fs,
+		metaPath,
+		dataPath,
 		l.ramComponent,
 		l.ramComponentRemoved,
+		l.cmp,
 	)
 	if err != nil {
-		return fmt.Errorf("%w: %w", ErrCreatingSSTable, err)
+		return failed(fmt.Errorf("%w: %w", ErrCreatingSSTable, err))
 	}
 
-	l.sstables[0] = append(l.sstables[0], newSSTable)
-	l.fileCnt++
-	l.ramComponent = make(map[string]struct{})
+	previousLevels := l.copyLevels()
+	l.levels[0] = append(l.levels[0], run{newSSTable})
+
+	err = l.writeManifest()
+	if err != nil {
+		l.levels = previousLevels
+		_ = newSSTable.Close()
+		return failed(fmt.Errorf("%w: %w", ErrWritingManifest, err))
+	}
+	l.stats.recordFlush(start, newSSTable)
+	info.Table = tableInfo(0, newSSTable)
+	info.Duration = time.Since(start)
+	l.logger.Info("flushed memtable",
+		"keys", info.Keys,
+		"table", info.Table.MetaPath,
+		"bytes", info.Table.FileSize,
+		"duration", info.Duration)
+	l.listener.flushEnd(info)
+	l.listener.tablesCreated([]TableInfo{info.Table})
+
+	l.ramComponent = make(map[string]sstable.TableElement)
 	l.ramComponentRemoved = make(map[string]struct{})
 
 	err = l.mergeSSTables()
@@ -152,35 +573,61 @@ func (l *LSMTree) flushRAMComponent() error {
 	return nil
 }
 
+// mergeSSTables merges every level that has grown to MaxLevelSize runs into
+// a single run of the next level. A level can be larger than that only if a
+// previous merge failed.
 func (l *LSMTree) mergeSSTables() error {
-	for level := 0; level < len(l.sstables); level++ {
-		if len(l.sstables[level]) == common.MaxLevelSize {
-			newSSTable, err := sstable.New(
-				filepath.Join(common.MetaDataDir, strconv.Itoa(l.fileCnt)),
-				filepath.Join(common.DataDir, strconv.Itoa(l.fileCnt)),
-				l.sstables[level],
+	for level := 0; level < len(l.levels); level++ {
+		if len(l.levels[level]) >= common.MaxLevelSize {
+			mergedTables := make([]*sstable.SSTable, 0)
+			for _, r := range l.levels[level] {
+				mergedTables = append(mergedTables, r...)
+			}
+
+			info := CompactionInfo{Level: level + 1, Inputs: tableInfos(level, mergedTables)}
+			l.listener.compactionBegin(info)
+			start := time.Now()
+			newRun, err := sstable.Merge(
+				context.Background(),
+				l.fs,
+				mergedTables,
+				l.cmp,
+				sstable.MergeOptions{
+					TargetFileSize: l.targetFileSize,
+					Subcompactions: l.maxSubcompactions,
+					Now:            l.now().UnixNano(),
+					Filter:         l.mergeFilter(level + 1),
+					MergeOperator:  l.mergeOperator,
+				},
+				l.nextTablePaths,
 			)
 			if err != nil {
+				l.finishCompaction(info, start, mergedTables, nil, err)
 				return err
 			}
 
-			for _, table := range l.sstables[level] {
-				err = table.Remove()
-				if err != nil {
-					return fmt.Errorf("%w: %w", ErrRemovingSSTable, err)
-				}
+			previousLevels := l.copyLevels()
+			l.levels[level] = make([]run, 0)
+			if len(l.levels) == level+1 {
+				l.levels = append(l.levels, make([]run, 0))
 			}
-			l.sstables[level] = make([]*sstable.SSTable, 0)
-
-			if level == len(l.sstables)-1 {
-				l.sstables = l.sstables[:level+1]
+			if len(newRun) > 0 {
+				l.levels[level+1] = append(l.levels[level+1], newRun)
 			}
 
-			l.fileCnt++
-			if len(l.sstables) == level+1 {
-				l.sstables = append(l.sstables, make([]*sstable.SSTable, 0))
+			// The inputs are removed only after the manifest stops referencing them.
+			err = l.writeManifest()
+			if err != nil {
+				l.levels = previousLevels
+				closeTables(newRun)
+				err = fmt.Errorf("%w: %w", ErrWritingManifest, err)
+				l.finishCompaction(info, start, mergedTables, nil, err)
+				return err
 			}
-			l.sstables[level+1] = append(l.sstables[level+1], newSSTable)
+
+			l.finishCompaction(info, start, mergedTables, newRun, nil)
+			l.removeTables(mergedTables)
+			l.listener.tablesDeleted(info.Inputs)
 		}
 	}
 
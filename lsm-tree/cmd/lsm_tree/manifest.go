@@ -0,0 +1,204 @@
+package lsm_tree
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"hw1/internal/common"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+// manifest is the durable list of live sstables. A table becomes a part of the
+// tree only when a manifest referencing it is written, and its files are
+// removed only after a manifest without it is written.
+type manifest struct {
+	Comparator string    `json:"comparator"`
+	FileCnt    int       `json:"file_cnt"`
+	Levels     [][][]int `json:"levels"`
+}
+
+func (l *LSMTree) writeManifest() error {
+	data, err := l.encodeManifest()
+	if err != nil {
+		return err
+	}
+
+	return vfs.WriteFileAtomic(l.fs, filepath.Join(l.dir, common.ManifestFile), data)
+}
+
+func (l *LSMTree) encodeManifest() ([]byte, error) {
+	m := manifest{
+		Comparator: l.cmp.Name(),
+		FileCnt:    l.fileCnt,
+		Levels:     make([][][]int, len(l.levels)),
+	}
+	for level := range l.levels {
+		m.Levels[level] = make([][]int, len(l.levels[level]))
+		for i, r := range l.levels[level] {
+			m.Levels[level][i] = make([]int, len(r))
+			for j, table := range r {
+				number, err := tableNumber(table)
+				if err != nil {
+					return nil, err
+				}
+				m.Levels[level][i][j] = number
+			}
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+func readManifest(fileSystem vfs.FS, dir string) (*manifest, error) {
+	file, err := fileSystem.Open(filepath.Join(dir, common.ManifestFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptedManifest, err)
+	}
+	return m, nil
+}
+
+func tableNumber(table *sstable.SSTable) (int, error) {
+	return strconv.Atoi(filepath.Base(table.MetaPath()))
+}
+
+func (l *LSMTree) tablePaths(number int) (string, string) {
+	return tablePaths(l.dir, number)
+}
+
+func tablePaths(dir string, number int) (string, string) {
+	return filepath.Join(dir, common.MetaDataDir, strconv.Itoa(number)),
+		filepath.Join(dir, common.DataDir, strconv.Itoa(number))
+}
+
+// adoptTables builds the tree from the tables on disk when there is no
+// manifest, as in a directory written before the manifest existed. Every
+// table with both files becomes a run of the first level, from the oldest to
+// the newest by file number, and a manifest listing them is written.
+func (l *LSMTree) adoptTables() error {
+	metaNumbers, err := l.tableNumbersIn(common.MetaDataDir)
+	if err != nil {
+		return err
+	}
+	dataNumbers, err := l.tableNumbersIn(common.DataDir)
+	if err != nil {
+		return err
+	}
+	for _, number := range slices.Concat(metaNumbers, dataNumbers) {
+		l.fileCnt = max(l.fileCnt, number+1)
+	}
+
+	for _, number := range metaNumbers {
+		if _, ok := slices.BinarySearch(dataNumbers, number); !ok {
+			continue
+		}
+		metaPath, dataPath := l.tablePaths(number)
+		table, err := sstable.Open(l.fs, metaPath, dataPath, l.cmp)
+		if err != nil {
+			return err
+		}
+		l.levels[0] = append(l.levels[0], run{table})
+	}
+	if len(l.levels[0]) == 0 {
+		return nil
+	}
+
+	l.logger.Info("adopted tables without a manifest", "dir", l.dir, "tables", len(l.levels[0]))
+	if l.readOnly {
+		return nil
+	}
+	return l.writeManifest()
+}
+
+// tableNumbersIn returns the sorted numbers of the table files in the data
+// or metadata directory.
+func (l *LSMTree) tableNumbersIn(dir string) ([]int, error) {
+	names, err := l.fs.ReadDir(filepath.Join(l.dir, dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(names))
+	for _, name := range names {
+		if number, err := strconv.Atoi(name); err == nil && number >= 0 {
+			numbers = append(numbers, number)
+		}
+	}
+	slices.Sort(numbers)
+	return numbers, nil
+}
+
+// removeObsoleteFiles deletes everything a crash could leave behind: temporary
+// files and tables that are no longer or not yet referenced by the manifest.
+// It must only run with a manifest loaded, as any other file is deleted.
+func (l *LSMTree) removeObsoleteFiles() error {
+	live := make(map[string]struct{})
+	for _, table := range l.allTables() {
+		live[filepath.Base(table.MetaPath())] = struct{}{}
+	}
+
+	return l.removeFiles(func(name string) bool {
+		_, ok := live[name]
+		return !ok
+	})
+}
+
+// removeTempFiles deletes only the temporary files of interrupted writes.
+func (l *LSMTree) removeTempFiles() error {
+	return l.removeFiles(func(name string) bool {
+		return strings.HasSuffix(name, common.TempSuffix)
+	})
+}
+
+func (l *LSMTree) removeFiles(obsolete func(name string) bool) error {
+	for _, dir := range []string{common.MetaDataDir, common.DataDir} {
+		dir = filepath.Join(l.dir, dir)
+		names, err := l.fs.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if !obsolete(name) {
+				continue
+			}
+			if err = l.fs.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+			l.logger.Info("removed obsolete file", "path", filepath.Join(dir, name))
+		}
+	}
+
+	err := l.fs.Remove(filepath.Join(l.dir, common.ManifestFile+common.TempSuffix))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package lsm_tree
+
+import (
+	"context"
+	"fmt"
+
+	"hw1/internal/merge_operator"
+	"hw1/internal/sstable"
+)
+
+type MergeOperator = merge_operator.MergeOperator
+
+var (
+	UInt64Add    = merge_operator.UInt64Add
+	StringAppend = merge_operator.StringAppend
+)
+
+// Merge writes an operand that the merge operator folds over the value of
+// the key on reads and merges, so read-modify-write needs no read. An
+// operand over an absent or deleted key is merged as if there was no value.
+func (l *LSMTree) Merge(key string, operand string) error {
+	return l.MergeContext(context.Background(), key, operand)
+}
+
+func (l *LSMTree) MergeContext(ctx context.Context, key string, operand string) error {
+	return l.write(ctx, newTrace(OperationMerge, key), func() error {
+		return l.merge(key, operand)
+	})
+}
+
+func (l *LSMTree) merge(key string, operand string) error {
+	if err := l.checkWritable(); err != nil {
+		return err
+	}
+	if l.mergeOperator == nil {
+		return fmt.Errorf("%w: %w", ErrMerging, merge_operator.ErrNoMergeOperator)
+	}
+
+	operands := []string{operand}
+	var base *sstable.TableElement
+	if element, ok := l.ramComponent[key]; ok {
+		if element.IsMergeOperand {
+			operands = append(operands, element.Data)
+		} else {
+			base = &element
+		}
+	} else if _, ok := l.ramComponentRemoved[key]; ok {
+		base = &sstable.TableElement{Value: key, IsTombstone: true}
+	}
+
+	element, err := sstable.ResolveOperands(l.mergeOperator, key, operands, base, l.now().UnixNano(), false)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMerging, err)
+	}
+	return l.add(*element)
+}
@@ -0,0 +1,183 @@
+package lsm_tree
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"hw1/internal/metrics"
+	"hw1/internal/sstable"
+)
+
+type HistogramSnapshot = metrics.HistogramSnapshot
+
+// stats accumulates the counters of the tree under its lock.
+type stats struct {
+	flushes                  int64
+	flushDuration            time.Duration
+	flushBytesWritten        int64
+	compactions              int64
+	compactionDuration       time.Duration
+	compactionBytesRead      int64
+	compactionBytesWritten   int64
+	bloomFilterUseful        int64
+	bloomFilterFalsePositive int64
+	readLatency              *metrics.Histogram
+}
+
+func newStats() *stats {
+	return &stats{readLatency: metrics.NewHistogram(metrics.LatencyBuckets)}
+}
+
+type LevelMetrics struct {
+	Runs   int
+	Tables int
+	Bytes  int64
+}
+
+// Metrics is a snapshot of the tree state and of the counters accumulated
+// since it was opened. Compactions include the merges of full levels. There is
+// no block cache hit rate: tables are read through the file system, which
+// does the caching, and the tree keeps no cache of its own.
+type Metrics struct {
+	Levels         []LevelMetrics
+	MemtableKeys   int
+	MemtableBytes  int64
+	Flushes        int64
+	FlushDuration  time.Duration
+	FlushBytes     int64
+	Compactions    int64
+	CompactionTime time.Duration
+	// Bytes of the input and output tables of compactions.
+	CompactionBytesRead    int64
+	CompactionBytesWritten int64
+	// WriteAmplification is the ratio of all bytes written by flushes and
+	// compactions to the bytes written by flushes alone.
+	WriteAmplification float64
+	// Point reads skipped a table thanks to its bloom filter, or read it and
+	// found no key.
+	BloomFilterUseful        int64
+	BloomFilterFalsePositive int64
+	// ReadLatency is measured in seconds for Get and SearchKey.
+	ReadLatency HistogramSnapshot
+}
+
+func (l *LSMTree) Metrics() (*Metrics, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+
+	m := &Metrics{
+		Levels:                   make([]LevelMetrics, len(l.levels)),
+		MemtableKeys:             len(l.ramComponent) + len(l.ramComponentRemoved),
+		Flushes:                  l.stats.flushes,
+		FlushDuration:            l.stats.flushDuration,
+		FlushBytes:               l.stats.flushBytesWritten,
+		Compactions:              l.stats.compactions,
+		CompactionTime:           l.stats.compactionDuration,
+		CompactionBytesRead:      l.stats.compactionBytesRead,
+		CompactionBytesWritten:   l.stats.compactionBytesWritten,
+		BloomFilterUseful:        l.stats.bloomFilterUseful,
+		BloomFilterFalsePositive: l.stats.bloomFilterFalsePositive,
+		ReadLatency:              l.stats.readLatency.Snapshot(),
+	}
+
+	for level, runs := range l.levels {
+		m.Levels[level].Runs = len(runs)
+		for _, r := range runs {
+			m.Levels[level].Tables += len(r)
+			m.Levels[level].Bytes += tablesBytes(r)
+		}
+	}
+	for key, element := range l.ramComponent {
+		m.MemtableBytes += int64(len(key) + len(element.Data) + 1)
+	}
+	for key := range l.ramComponentRemoved {
+		m.MemtableBytes += int64(len(key) + 1)
+	}
+	if m.FlushBytes > 0 {
+		m.WriteAmplification = float64(m.FlushBytes+m.CompactionBytesWritten) / float64(m.FlushBytes)
+	}
+
+	return m, nil
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	t := metrics.NewTextWriter(w)
+
+	levelGauge := func(name string, help string, value func(LevelMetrics) float64) {
+		t.Family(name, help, "gauge")
+		for level, lm := range m.Levels {
+			t.Sample(name, value(lm), metrics.Label{Name: "level", Value: strconv.Itoa(level)})
+		}
+	}
+	levelGauge("lsm_level_runs", "Number of runs in the level.", func(lm LevelMetrics) float64 { return float64(lm.Runs) })
+	levelGauge("lsm_level_tables", "Number of tables in the level.", func(lm LevelMetrics) float64 { return float64(lm.Tables) })
+	levelGauge("lsm_level_bytes", "Size of the tables in the level.", func(lm LevelMetrics) float64 { return float64(lm.Bytes) })
+
+	single := func(name string, help string, kind string, value float64) {
+		t.Family(name, help, kind)
+		t.Sample(name, value)
+	}
+	single("lsm_memtable_keys", "Number of keys in the memtable.", "gauge", float64(m.MemtableKeys))
+	single("lsm_memtable_bytes", "Approximate size of the memtable.", "gauge", float64(m.MemtableBytes))
+	single("lsm_flushes_total", "Number of memtable flushes.", "counter", float64(m.Flushes))
+	single("lsm_flush_seconds_total", "Time spent flushing the memtable.", "counter", m.FlushDuration.Seconds())
+	single("lsm_flush_bytes_written_total", "Bytes written by flushes.", "counter", float64(m.FlushBytes))
+	single("lsm_compactions_total", "Number of compactions and level merges.", "counter", float64(m.Compactions))
+	single("lsm_compaction_seconds_total", "Time spent in compactions.", "counter", m.CompactionTime.Seconds())
+	single("lsm_compaction_bytes_read_total", "Bytes of the tables compacted.", "counter", float64(m.CompactionBytesRead))
+	single("lsm_compaction_bytes_written_total", "Bytes written by compactions.", "counter", float64(m.CompactionBytesWritten))
+	single("lsm_write_amplification", "Bytes written by flushes and compactions per byte flushed.", "gauge", m.WriteAmplification)
+	single("lsm_bloom_filter_useful_total", "Table reads avoided by bloom filters.", "counter", float64(m.BloomFilterUseful))
+	single("lsm_bloom_filter_false_positive_total", "Table reads that passed the bloom filter but found no key.", "counter", float64(m.BloomFilterFalsePositive))
+	t.Histogram("lsm_read_latency_seconds", "Latency of point reads.", m.ReadLatency)
+
+	return t.Err()
+}
+
+// MetricsHandler serves the metrics of the tree in the Prometheus text format.
+func MetricsHandler(l *LSMTree) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m, err := l.Metrics()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err = m.WritePrometheus(w); err != nil {
+			http.Error(w, fmt.Sprintf("writing metrics: %v", err), http.StatusInternalServerError)
+		}
+	})
+}
+
+func (s *stats) recordFlush(start time.Time, table *sstable.SSTable) {
+	s.flushes++
+	s.flushDuration += time.Since(start)
+	s.flushBytesWritten += table.FileSize()
+}
+
+func (s *stats) recordCompaction(start time.Time, inputs []*sstable.SSTable, outputs []*sstable.SSTable) {
+	s.compactions++
+	s.compactionDuration += time.Since(start)
+	s.compactionBytesRead += tablesBytes(inputs)
+	s.compactionBytesWritten += tablesBytes(outputs)
+}
+
+func (s *stats) recordRead(start time.Time) {
+	s.readLatency.Observe(time.Since(start).Seconds())
+}
+
+func tablesBytes(tables []*sstable.SSTable) int64 {
+	var bytes int64
+	for _, table := range tables {
+		bytes += table.FileSize()
+	}
+	return bytes
+}
@@ -0,0 +1,30 @@
+package lsm_tree
+
+import "context"
+
+// mutex is the tree lock. Unlike sync.Mutex, waiting for it can be given up
+// when a context is done, e.g. while a long compaction holds it.
+type mutex struct {
+	ch chan struct{}
+}
+
+func newMutex() mutex {
+	return mutex{ch: make(chan struct{}, 1)}
+}
+
+func (m *mutex) Lock() {
+	m.ch <- struct{}{}
+}
+
+func (m *mutex) LockContext(ctx context.Context) error {
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *mutex) Unlock() {
+	<-m.ch
+}
@@ -0,0 +1,61 @@
+package lsm_tree
+
+import (
+	"log/slog"
+	"time"
+
+	"hw1/internal/common"
+	"hw1/internal/comparator"
+	"hw1/internal/vfs"
+)
+
+type Comparator = comparator.Comparator
+
+type Options struct {
+	Comparator Comparator
+	// Dir holds the manifest and the data and metadata directories.
+	Dir string
+	FS  vfs.FS
+	// ReadOnly opens the tree without changing its directory: Open neither
+	// creates it nor removes or adopts files, and writes fail with
+	// ErrReadOnly.
+	ReadOnly bool
+	// Merges split their output into tables of about TargetFileSize bytes.
+	TargetFileSize int64
+	// Large merges are split into up to MaxSubcompactions key ranges merged
+	// in parallel. Zero means no splitting.
+	MaxSubcompactions int
+	CompactionFilter  CompactionFilter
+	// MergeOperator resolves operands written by Merge. Trees with operands
+	// can't be read or compacted without it.
+	MergeOperator MergeOperator
+	EventListener EventListener
+	// Logger receives structured records about opening, flushes, compactions
+	// and removed files. Nothing is logged by default.
+	Logger *slog.Logger
+	Tracer Tracer
+	// Now is the clock expiry times are computed and checked with.
+	Now func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.Comparator == nil {
+		o.Comparator = comparator.Bytewise
+	}
+	if o.Dir == "" {
+		o.Dir = "."
+	}
+	if o.FS == nil {
+		o.FS = vfs.Default
+	}
+	if o.TargetFileSize == 0 {
+		o.TargetFileSize = common.TargetFileSize
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(common.DiscardHandler{})
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
@@ -0,0 +1,353 @@
+package lsm_tree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"hw1/internal/sstable"
+)
+
+type Bound = sstable.Bound
+
+const (
+	Inclusive = sstable.Inclusive
+	Exclusive = sstable.Exclusive
+	Unbounded = sstable.Unbounded
+)
+
+// The zero value selects every key in [keyL, keyR] in ascending order.
+// Limit == 0 means no limit.
+type SearchRangeOptions struct {
+	LeftBound  Bound
+	RightBound Bound
+	Offset     int
+	Limit      int
+	Reverse    bool
+	CountOnly  bool
+}
+
+// Keys and Values are nil in count mode. Values[i] is the value of Keys[i],
+// read together with the key.
+type RangeResult struct {
+	Keys   []string
+	Values []string
+	Count  int
+}
+
+// Range searches check for cancellation every that many merged elements.
+const cancelCheckInterval = 1000
+
+// Expiring elements count as tombstones for hasTombstone, as they may read
+// as deleted. Tables is zero for the RAM component.
+type rangeSource struct {
+	it           sstable.ElementIterator
+	count        int
+	tables       int
+	first, last  string
+	hasTombstone bool
+}
+
+func (l *LSMTree) SearchRangeWithOptions(keyL string, keyR string, opts SearchRangeOptions) (*RangeResult, error) {
+	return l.SearchRangeContext(context.Background(), keyL, keyR, opts)
+}
+
+// SearchRangeContext is SearchRangeWithOptions that stops waiting for the
+// tree lock or merging the sources once ctx is done.
+func (l *LSMTree) SearchRangeContext(ctx context.Context, keyL string, keyR string, opts SearchRangeOptions) (res *RangeResult, err error) {
+	if err = l.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	trace := newTrace(OperationSearchRange, keyL)
+	defer func() { l.finishTrace(trace, err) }()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.LeftBound != Unbounded && opts.RightBound != Unbounded && l.cmp.Compare(keyL, keyR) > 0 {
+		return nil, ErrInvalidRange
+	}
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return nil, fmt.Errorf("%w: negative offset or limit", ErrInvalidRange)
+	}
+
+	sources, err := l.rangeSources(keyL, keyR, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSearching, err)
+	}
+	for _, source := range sources {
+		trace.TablesProbed += source.tables
+		if source.tables > 0 {
+			source.it = &countingIterator{it: source.it, count: &trace.RecordsRead}
+		}
+	}
+
+	if opts.CountOnly {
+		if count, ok := l.countDisjointSources(sources); ok {
+			return &RangeResult{Count: applyOffsetAndLimit(count, opts)}, nil
+		}
+	}
+
+	res, err = l.mergeSources(ctx, sources, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrSearching, err)
+	}
+	return res, nil
+}
+
+// rangeSources returns the iterators over the range ordered from the newest
+// source to the oldest one.
+func (l *LSMTree) rangeSources(keyL string, keyR string, opts SearchRangeOptions) ([]*rangeSource, error) {
+	tableOpts := sstable.RangeOptions{
+		LeftBound:  opts.LeftBound,
+		RightBound: opts.RightBound,
+		Reverse:    opts.Reverse,
+	}
+
+	sources := make([]*rangeSource, 0)
+	if source := l.ramComponentSource(keyL, keyR, tableOpts); source != nil {
+		sources = append(sources, source)
+	}
+
+	for level := range len(l.levels) {
+		for i := len(l.levels[level]) - 1; i >= 0; i-- {
+			source, err := runSource(l.levels[level][i], keyL, keyR, tableOpts)
+			if err != nil {
+				return nil, err
+			}
+			if source != nil {
+				sources = append(sources, source)
+			}
+		}
+	}
+
+	return sources, nil
+}
+
+// runSource chains the iterators of the run's tables, which is enough since
+// their key ranges are disjoint.
+func runSource(r run, keyL string, keyR string, opts sstable.RangeOptions) (*rangeSource, error) {
+	source := &rangeSource{}
+	iterators := make([]sstable.ElementIterator, 0)
+	for _, table := range r {
+		L, R, err := table.Bounds(keyL, keyR, opts)
+		if err != nil {
+			return nil, err
+		}
+		if L > R {
+			continue
+		}
+
+		if source.count == 0 {
+			first, err := table.ElementAt(L)
+			if err != nil {
+				return nil, err
+			}
+			source.first = first.Value
+		}
+		last, err := table.ElementAt(R)
+		if err != nil {
+			return nil, err
+		}
+		source.last = last.Value
+
+		iterators = append(iterators, table.NewIndexIterator(L, R, opts.Reverse))
+		source.count += R - L + 1
+		source.tables++
+		source.hasTombstone = source.hasTombstone || table.Tombstones() > 0 || table.Expiring() > 0
+	}
+	if source.count == 0 {
+		return nil, nil
+	}
+
+	if opts.Reverse {
+		for i, j := 0, len(iterators)-1; i < j; i, j = i+1, j-1 {
+			iterators[i], iterators[j] = iterators[j], iterators[i]
+		}
+	}
+	source.it = &concatIterator{iterators: iterators}
+
+	return source, nil
+}
+
+func (l *LSMTree) ramComponentSource(keyL string, keyR string, opts sstable.RangeOptions) *rangeSource {
+	elements := make([]*sstable.TableElement, 0)
+	hasTombstone := false
+	for key, element := range l.ramComponent {
+		if l.inBounds(key, keyL, keyR, opts) {
+			elements = append(elements, &element)
+			hasTombstone = hasTombstone || element.ExpiresAt != 0
+		}
+	}
+	for key := range l.ramComponentRemoved {
+		if l.inBounds(key, keyL, keyR, opts) {
+			elements = append(elements, &sstable.TableElement{Value: key, IsTombstone: true})
+			hasTombstone = true
+		}
+	}
+	if len(elements) == 0 {
+		return nil
+	}
+
+	sort.Slice(elements, func(i, j int) bool {
+		return l.cmp.Compare(elements[i].Value, elements[j].Value) < 0
+	})
+	source := &rangeSource{
+		count:        len(elements),
+		first:        elements[0].Value,
+		last:         elements[len(elements)-1].Value,
+		hasTombstone: hasTombstone,
+	}
+	if opts.Reverse {
+		for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+			elements[i], elements[j] = elements[j], elements[i]
+		}
+	}
+	source.it = &sliceIterator{elements: elements}
+
+	return source
+}
+
+// countDisjointSources counts the keys without decoding them: it's possible
+// when no source has tombstones and key spans of the sources don't overlap,
+// so every element of every source is a distinct live key.
+func (l *LSMTree) countDisjointSources(sources []*rangeSource) (int, bool) {
+	spans := make([]*rangeSource, len(sources))
+	copy(spans, sources)
+	sort.Slice(spans, func(i, j int) bool {
+		return l.cmp.Compare(spans[i].first, spans[j].first) < 0
+	})
+
+	count := 0
+	for i, source := range spans {
+		if source.hasTombstone {
+			return 0, false
+		}
+		if i > 0 && l.cmp.Compare(spans[i-1].last, source.first) >= 0 {
+			return 0, false
+		}
+		count += source.count
+	}
+
+	return count, true
+}
+
+func (l *LSMTree) mergeSources(ctx context.Context, sources []*rangeSource, opts SearchRangeOptions) (*RangeResult, error) {
+	inputs := make([]sstable.ElementIterator, len(sources))
+	for i, source := range sources {
+		inputs[len(sources)-1-i] = source.it
+	}
+	merged := sstable.NewMergingIterator(inputs, l.cmp, opts.Reverse)
+	now := l.now().UnixNano()
+	if l.mergeOperator != nil {
+		merged.FoldOperands(l.mergeOperator, now, true)
+	}
+
+	res := &RangeResult{}
+	if !opts.CountOnly {
+		res.Keys = make([]string, 0)
+		res.Values = make([]string, 0)
+	}
+
+	skipped, checked := 0, 0
+	for opts.Limit == 0 || res.Count < opts.Limit {
+		element, err := merged.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if merged.Consumed()-checked >= cancelCheckInterval {
+			checked = merged.Consumed()
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		if element.IsTombstone || element.Expired(now) {
+			continue
+		}
+		if skipped < opts.Offset {
+			skipped++
+			continue
+		}
+
+		res.Count++
+		if !opts.CountOnly {
+			res.Keys = append(res.Keys, element.Value)
+			res.Values = append(res.Values, element.Data)
+		}
+	}
+
+	return res, nil
+}
+
+func (l *LSMTree) inBounds(key string, keyL string, keyR string, opts sstable.RangeOptions) bool {
+	switch opts.LeftBound {
+	case Inclusive:
+		if l.cmp.Compare(key, keyL) < 0 {
+			return false
+		}
+	case Exclusive:
+		if l.cmp.Compare(key, keyL) <= 0 {
+			return false
+		}
+	}
+
+	switch opts.RightBound {
+	case Inclusive:
+		if l.cmp.Compare(key, keyR) > 0 {
+			return false
+		}
+	case Exclusive:
+		if l.cmp.Compare(key, keyR) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func applyOffsetAndLimit(count int, opts SearchRangeOptions) int {
+	count = max(count-opts.Offset, 0)
+	if opts.Limit > 0 {
+		count = min(count, opts.Limit)
+	}
+	return count
+}
+
+type sliceIterator struct {
+	elements []*sstable.TableElement
+}
+
+func (it *sliceIterator) Next() (*sstable.TableElement, error) {
+	if len(it.elements) == 0 {
+		return nil, io.EOF
+	}
+	element := it.elements[0]
+	it.elements = it.elements[1:]
+	return element, nil
+}
+
+// countingIterator counts the elements read from the wrapped iterator.
+type countingIterator struct {
+	it    sstable.ElementIterator
+	count *int
+}
+
+func (it *countingIterator) Next() (*sstable.TableElement, error) {
+	element, err := it.it.Next()
+	if err == nil {
+		*it.count++
+	}
+	return element, err
+}
@@ -0,0 +1,32 @@
+package lsm_tree
+
+import (
+	"context"
+
+	"hw1/internal/sstable"
+)
+
+// ReadStats counts one candidate table per run for point reads: the tables
+// of a run have disjoint key ranges, so the others are never considered.
+type ReadStats = sstable.ReadStats
+
+type readStatsKey struct{}
+
+// WithReadStats returns a context that makes SearchKeyContext and
+// GetContext add their work to stats. Stats must not be shared by
+// concurrent reads.
+func WithReadStats(ctx context.Context, stats *ReadStats) context.Context {
+	return context.WithValue(ctx, readStatsKey{}, stats)
+}
+
+func readStatsFrom(ctx context.Context) *ReadStats {
+	stats, _ := ctx.Value(readStatsKey{}).(*ReadStats)
+	return stats
+}
+
+// SearchKeyWithStats is SearchKey that also returns the work it did.
+func (l *LSMTree) SearchKeyWithStats(s string) (bool, ReadStats, error) {
+	stats := ReadStats{}
+	found, err := l.SearchKeyContext(WithReadStats(context.Background(), &stats), s)
+	return found, stats, err
+}
@@ -0,0 +1,230 @@
+package lsm_tree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"hw1/internal/common"
+	"hw1/internal/sstable"
+)
+
+type RepairReport struct {
+	// Tables is the number of tables scanned, Unreadable of those that
+	// could not be read at all.
+	Tables     int
+	Unreadable int
+	// Recovered and Lost count the records that were salvaged and the index
+	// entries that were skipped.
+	Recovered int
+	Lost      int
+}
+
+// Repair rebuilds the tree in opts.Dir, which must not be open, from whatever
+// its tables still contain. Every table in the directory is salvaged, listed
+// in the manifest or not, and the records that can be read are rewritten into
+// a single run that a new manifest lists. Tables are ordered by the manifest
+// if it is readable, the ones it doesn't list being older, and by file number
+// otherwise. Tables read without a loss are removed, while damaged ones are
+// moved to the lost directory, so a repair deletes nothing it couldn't read.
+func Repair(opts Options) (*RepairReport, error) {
+	l := NewWithOptions(opts)
+	report := &RepairReport{}
+
+	numbers, err := l.repairOrder()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+	}
+	for _, number := range numbers {
+		l.fileCnt = max(l.fileCnt, number+1)
+	}
+
+	salvaged := make([]*sstable.SSTable, 0, len(numbers))
+	defer func() { closeTables(salvaged) }()
+	intact, damaged := make([]int, 0, len(numbers)), make([]int, 0)
+	for _, number := range numbers {
+		report.Tables++
+		table, recovered, lost, err := l.salvageTable(number)
+		if err != nil {
+			l.logger.Warn("table is unreadable", "number", number, "error", err)
+			report.Unreadable++
+			damaged = append(damaged, number)
+			continue
+		}
+		report.Recovered += recovered
+		report.Lost += lost
+		if lost > 0 {
+			damaged = append(damaged, number)
+		} else {
+			intact = append(intact, number)
+		}
+		if table != nil {
+			salvaged = append(salvaged, table)
+		}
+	}
+
+	if len(salvaged) > 0 {
+		merged, err := sstable.Merge(context.Background(), l.fs, salvaged, l.cmp, sstable.MergeOptions{
+			TargetFileSize: l.targetFileSize,
+			MergeOperator:  l.mergeOperator,
+		}, l.nextTablePaths)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+		}
+		l.levels[0] = []run{merged}
+	}
+	defer l.closeTables()
+
+	// The damaged tables are moved away before the manifest stops listing
+	// them, or opening the tree could collect them as obsolete.
+	if err = l.moveToLost(damaged); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+	}
+	if err = l.writeManifest(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+	}
+	// Neither the salvaged copies nor the old tables are referenced anymore.
+	for _, table := range salvaged {
+		if err = table.Remove(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+		}
+	}
+	salvaged = nil
+	if err = l.removeTableFiles(intact); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+	}
+	if err = l.removeTempFiles(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRepairing, err)
+	}
+
+	l.logger.Info("repaired lsm tree",
+		"dir", l.dir,
+		"tables", report.Tables,
+		"unreadable", report.Unreadable,
+		"recovered", report.Recovered,
+		"lost", report.Lost)
+
+	return report, nil
+}
+
+// repairOrder returns the numbers of the tables in the directory, from the
+// oldest to the newest. A table missing one of its files is included, so it
+// is reported as unreadable.
+func (l *LSMTree) repairOrder() ([]int, error) {
+	m, err := readManifest(l.fs, l.dir)
+	if err != nil && !errors.Is(err, ErrCorruptedManifest) {
+		return nil, err
+	}
+	if m != nil && m.Comparator != l.cmp.Name() {
+		return nil, sstable.ErrComparatorMismatch
+	}
+
+	metaNumbers, err := l.tableNumbersIn(common.MetaDataDir)
+	if err != nil {
+		return nil, err
+	}
+	dataNumbers, err := l.tableNumbersIn(common.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	numbers := slices.Concat(metaNumbers, dataNumbers)
+	slices.Sort(numbers)
+	numbers = slices.Compact(numbers)
+	if m == nil {
+		return numbers, nil
+	}
+
+	listed := make([]int, 0)
+	for level := len(m.Levels) - 1; level >= 0; level-- {
+		for _, r := range m.Levels[level] {
+			listed = append(listed, r...)
+		}
+	}
+	numbers = slices.DeleteFunc(numbers, func(number int) bool {
+		return slices.Contains(listed, number)
+	})
+	return append(numbers, listed...), nil
+}
+
+// removeTableFiles removes both files of every table, ignoring missing ones.
+func (l *LSMTree) removeTableFiles(numbers []int) error {
+	for _, number := range numbers {
+		metaPath, dataPath := l.tablePaths(number)
+		for _, path := range []string{metaPath, dataPath} {
+			if err := l.fs.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveToLost moves the files of damaged tables to the lost directory, where
+// the tree doesn't collect them as obsolete, and syncs both directories.
+func (l *LSMTree) moveToLost(numbers []int) error {
+	if len(numbers) == 0 {
+		return nil
+	}
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		if err := l.fs.MkdirAll(filepath.Join(l.dir, common.LostDir, subdir), 0770); err != nil {
+			return err
+		}
+	}
+
+	for _, number := range numbers {
+		for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+			name := strconv.Itoa(number)
+			err := l.fs.Rename(filepath.Join(l.dir, subdir, name), filepath.Join(l.dir, common.LostDir, subdir, name))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	for _, subdir := range []string{common.MetaDataDir, common.DataDir} {
+		for _, dir := range []string{filepath.Join(l.dir, common.LostDir, subdir), filepath.Join(l.dir, subdir)} {
+			if err := l.fs.SyncDir(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// salvageTable copies the readable records of a table into a new one. The
+// returned table is nil if nothing could be recovered.
+func (l *LSMTree) salvageTable(number int) (*sstable.SSTable, int, int, error) {
+	metaPath, dataPath := l.tablePaths(number)
+	elements, lost, err := sstable.Salvage(l.fs, metaPath, dataPath, l.cmp)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(elements) == 0 {
+		return nil, 0, lost, nil
+	}
+
+	metaPath, dataPath = l.nextTablePaths()
+	w, err := sstable.NewSSTWriter(l.fs, metaPath, dataPath, l.cmp)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for _, element := range elements {
+		if err = w.AddElement(element); err != nil {
+			w.Abort()
+			return nil, 0, 0, err
+		}
+	}
+	if err = w.Finish(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	table, err := sstable.Open(l.fs, metaPath, dataPath, l.cmp)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return table, len(elements), lost, nil
+}
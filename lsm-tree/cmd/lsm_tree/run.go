@@ -0,0 +1,108 @@
+package lsm_tree
+
+import (
+	"io"
+	"sort"
+
+	"hw1/internal/sstable"
+)
+
+// run is the output of a single flush or merge: non-empty tables ordered by
+// key with disjoint key ranges. Levels are limited in runs, not in tables.
+type run []*sstable.SSTable
+
+func (r run) smallest() string {
+	return r[0].Smallest()
+}
+
+func (r run) largest() string {
+	return r[len(r)-1].Largest()
+}
+
+func (r run) size() int {
+	size := 0
+	for _, table := range r {
+		size += table.Size()
+	}
+	return size
+}
+
+func (r run) tombstones() int {
+	tombstones := 0
+	for _, table := range r {
+		tombstones += table.Tombstones()
+	}
+	return tombstones
+}
+
+func (r run) expiring() int {
+	expiring := 0
+	for _, table := range r {
+		expiring += table.Expiring()
+	}
+	return expiring
+}
+
+// tableFor returns the only table of the run that may contain key.
+func (r run) tableFor(key string, cmp Comparator) *sstable.SSTable {
+	i := sort.Search(len(r), func(i int) bool {
+		return cmp.Compare(r[i].Largest(), key) >= 0
+	})
+	if i == len(r) || cmp.Compare(r[i].Smallest(), key) > 0 {
+		return nil
+	}
+	return r[i]
+}
+
+// Levels describes the tables of every run of every level, the newest run of
+// a level being the last one.
+func (l *LSMTree) Levels() ([][][]TableInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+
+	levels := make([][][]TableInfo, len(l.levels))
+	for level, runs := range l.levels {
+		levels[level] = make([][]TableInfo, len(runs))
+		for i, r := range runs {
+			levels[level][i] = tableInfos(level, r)
+		}
+	}
+	return levels, nil
+}
+
+func (l *LSMTree) allTables() []*sstable.SSTable {
+	tables := make([]*sstable.SSTable, 0)
+	for level := range l.levels {
+		for _, r := range l.levels[level] {
+			tables = append(tables, r...)
+		}
+	}
+	return tables
+}
+
+func (l *LSMTree) copyLevels() [][]run {
+	levels := make([][]run, len(l.levels))
+	for level := range l.levels {
+		levels[level] = append(make([]run, 0, len(l.levels[level])), l.levels[level]...)
+	}
+	return levels
+}
+
+type concatIterator struct {
+	iterators []sstable.ElementIterator
+}
+
+func (it *concatIterator) Next() (*sstable.TableElement, error) {
+	for len(it.iterators) > 0 {
+		element, err := it.iterators[0].Next()
+		if err == io.EOF {
+			it.iterators = it.iterators[1:]
+			continue
+		}
+		return element, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,56 @@
+package lsm_tree
+
+import "time"
+
+// Tracer collects a trace of every operation once it finishes. It's called
+// under the tree lock, so it must not call the tree and should return
+// quickly.
+type Tracer interface {
+	Trace(OperationTrace)
+}
+
+type Operation string
+
+const (
+	OperationAdd         Operation = "add"
+	OperationPut         Operation = "put"
+	OperationAddWithTTL  Operation = "add_with_ttl"
+	OperationMerge       Operation = "merge"
+	OperationDelete      Operation = "delete"
+	OperationSearchKey   Operation = "search_key"
+	OperationGet         Operation = "get"
+	OperationSearchRange Operation = "search_range"
+	OperationApply       Operation = "apply"
+)
+
+// OperationTrace describes where an operation spent its time. Key is the
+// left end of the range for range searches and empty for batches. There are
+// no cache hits: tables are read through the file system, which does the
+// caching, and the tree keeps no cache of its own to count them in.
+type OperationTrace struct {
+	Operation Operation
+	Key       string
+	Start     time.Time
+	Duration  time.Duration
+	// TablesProbed counts the tables whose key range covered the key, or
+	// that were merged by a range search.
+	TablesProbed     int
+	BloomFilterSkips int
+	// RecordsRead counts the records read from the table files: the binary
+	// search probes of point reads and the elements merged by range searches.
+	RecordsRead int
+	Err         error
+}
+
+func newTrace(op Operation, key string) *OperationTrace {
+	return &OperationTrace{Operation: op, Key: key, Start: time.Now()}
+}
+
+func (l *LSMTree) finishTrace(trace *OperationTrace, err error) {
+	if l.tracer == nil {
+		return
+	}
+	trace.Duration = time.Since(trace.Start)
+	trace.Err = err
+	l.tracer.Trace(*trace)
+}
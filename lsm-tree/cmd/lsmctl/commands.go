@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"hw1/cmd/lsm_tree"
+)
+
+var errNotFound = errors.New("key not found")
+
+func runGet(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	return e.inspectTree(func(tree *lsm_tree.LSMTree) error {
+		value, ok, err := tree.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNotFound
+		}
+		fmt.Fprintln(e.stdout, value)
+		return nil
+	})
+}
+
+func runPut(e *env, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+
+	return e.withTree(func(tree *lsm_tree.LSMTree) error {
+		if len(args) == 1 {
+			return tree.Add(args[0])
+		}
+		return tree.Put(args[0], args[1])
+	})
+}
+
+func runDelete(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	return e.withTree(func(tree *lsm_tree.LSMTree) error {
+		return tree.Delete(args[0])
+	})
+}
+
+// runScan prints the keys with their values, one per line separated by a
+// tab, or only their number with -count.
+func runScan(e *env, args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	reverse := flags.Bool("reverse", false, "")
+	limit := flags.Int("limit", 0, "")
+	countOnly := flags.Bool("count", false, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 2 {
+		return errUsage
+	}
+
+	opts := lsm_tree.SearchRangeOptions{
+		LeftBound:  lsm_tree.Unbounded,
+		RightBound: lsm_tree.Unbounded,
+		Limit:      *limit,
+		Reverse:    *reverse,
+		CountOnly:  *countOnly,
+	}
+	start, end := flags.Arg(0), flags.Arg(1)
+	if flags.NArg() > 0 {
+		opts.LeftBound = lsm_tree.Inclusive
+	}
+	if flags.NArg() > 1 {
+		opts.RightBound = lsm_tree.Inclusive
+	}
+
+	return e.inspectTree(func(tree *lsm_tree.LSMTree) error {
+		res, err := tree.SearchRangeWithOptions(start, end, opts)
+		if err != nil {
+			return err
+		}
+		if opts.CountOnly {
+			fmt.Fprintln(e.stdout, res.Count)
+			return nil
+		}
+
+		for i, key := range res.Keys {
+			fmt.Fprintf(e.stdout, "%s\t%s\n", key, res.Values[i])
+		}
+		return nil
+	})
+}
+
+func runCompact(e *env, args []string) error {
+	if len(args) != 0 && len(args) != 2 {
+		return errUsage
+	}
+
+	return e.withTree(func(tree *lsm_tree.LSMTree) error {
+		// Compactions don't include the RAM component.
+		if err := tree.Flush(); err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			return tree.CompactRange(context.Background(), args[0], args[1], nil)
+		}
+		return tree.CompactAll(context.Background(), nil)
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+func runLevels(e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	return e.inspectTree(func(tree *lsm_tree.LSMTree) error {
+		levels, err := tree.Levels()
+		if err != nil {
+			return err
+		}
+
+		for level, runs := range levels {
+			fmt.Fprintf(e.stdout, "level %d: %d runs\n", level, len(runs))
+			for i, tables := range runs {
+				fmt.Fprintf(e.stdout, "  run %d: %d tables\n", i, len(tables))
+				for _, table := range tables {
+					fmt.Fprintf(e.stdout, "    %s [%q, %q] elements=%d bytes=%d\n",
+						table.MetaPath, table.Smallest, table.Largest, table.Elements, table.FileSize)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// runDumpSST prints every element of a table, tombstones and merge operands
+// included, one per line.
+func runDumpSST(e *env, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	table, err := sstable.Open(vfs.Default, args[0], args[1], e.cmp)
+	if err != nil {
+		return err
+	}
+	defer table.Close()
+
+	it := table.NewIndexIterator(0, table.Size()-1, false)
+	for it.Len() > 0 {
+		element, err := it.Next()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, formatElement(element))
+	}
+	fmt.Fprintf(e.stdout, "%d elements, %d tombstones, %d expiring\n", table.Size(), table.Tombstones(), table.Expiring())
+	return nil
+}
+
+func formatElement(element *sstable.TableElement) string {
+	var line string
+	switch {
+	case element.IsTombstone:
+		line = fmt.Sprintf("delete %q", element.Value)
+	case element.IsMergeOperand:
+		line = fmt.Sprintf("merge %q %q", element.Value, element.Data)
+	default:
+		line = fmt.Sprintf("put %q %q", element.Value, element.Data)
+	}
+	if element.ExpiresAt != 0 {
+		line += " expires=" + time.Unix(0, element.ExpiresAt).UTC().Format(time.RFC3339Nano)
+	}
+	return line
+}
+
+// runCheck verifies every table file in the directory, printing the corrupted
+// ones, so a table the tree can't open is reported too. It then opens the
+// tree read-only, which validates the manifest, and checks that the tables of
+// every run are ordered and disjoint.
+func runCheck(e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	names, err := vfs.Default.ReadDir(filepath.Join(e.dir, common.MetaDataDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	corrupted := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, common.TempSuffix) {
+			continue
+		}
+		metaPath := filepath.Join(e.dir, common.MetaDataDir, name)
+		dataPath := filepath.Join(e.dir, common.DataDir, name)
+		if err = sstable.Verify(vfs.Default, metaPath, dataPath, e.cmp); err != nil {
+			fmt.Fprintf(e.stdout, "corrupted: %s: %v\n", metaPath, err)
+			corrupted++
+		}
+	}
+	if corrupted > 0 {
+		return fmt.Errorf("%d corrupted tables", corrupted)
+	}
+
+	return e.inspectTree(func(tree *lsm_tree.LSMTree) error {
+		levels, err := tree.Levels()
+		if err != nil {
+			return err
+		}
+
+		tables, elements := 0, 0
+		for level, runs := range levels {
+			for i, infos := range runs {
+				for j, info := range infos {
+					if j > 0 && e.cmp.Compare(infos[j-1].Largest, info.Smallest) >= 0 {
+						return fmt.Errorf("level %d run %d: %s overlaps %s", level, i, info.MetaPath, infos[j-1].MetaPath)
+					}
+					tables++
+					elements += info.Elements
+				}
+			}
+		}
+
+		fmt.Fprintf(e.stdout, "ok: %d tables, %d elements\n", tables, elements)
+		return nil
+	})
+}
+
+// runRepair rebuilds the tree from the readable records of its tables.
+func runRepair(e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	report, err := lsm_tree.Repair(lsm_tree.Options{Dir: e.dir, Comparator: e.cmp})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "repaired: %d tables (%d unreadable), %d records recovered, %d lost\n",
+		report.Tables, report.Unreadable, report.Recovered, report.Lost)
+	return nil
+}
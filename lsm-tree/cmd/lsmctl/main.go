@@ -0,0 +1,118 @@
+// Command lsmctl inspects and modifies a tree directory.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/comparator"
+)
+
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	usage string
+	run   func(env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"get":      {"get KEY", runGet},
+	"put":      {"put KEY [VALUE]", runPut},
+	"delete":   {"delete KEY", runDelete},
+	"scan":     {"scan [-reverse] [-limit N] [-count] [START [END]]", runScan},
+	"levels":   {"levels", runLevels},
+	"dump-sst": {"dump-sst META_PATH DATA_PATH", runDumpSST},
+	"check":    {"check", runCheck},
+	"repair":   {"repair", runRepair},
+	"compact":  {"compact [START END]", runCompact},
+}
+
+var commandOrder = []string{"get", "put", "delete", "scan", "levels", "dump-sst", "check", "repair", "compact"}
+
+// env holds the global flags and the output of a command.
+type env struct {
+	dir    string
+	cmp    lsm_tree.Comparator
+	stdout io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lsmctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dir := flags.String("dir", ".", "tree directory")
+	cmpName := flags.String("comparator", "bytewise", "key order of the tree: bytewise or reverse")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: lsmctl [-dir DIR] [-comparator NAME] COMMAND [ARGS]")
+		fmt.Fprintln(stderr, "commands:")
+		for _, name := range commandOrder {
+			fmt.Fprintln(stderr, "  "+commands[name].usage)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cmp, err := parseComparator(*cmpName)
+	if err != nil || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return 2
+	}
+
+	err = cmd.run(&env{dir: *dir, cmp: cmp, stdout: stdout}, flags.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(stderr, "usage: lsmctl "+cmd.usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "lsmctl:", err)
+		return 1
+	}
+	return 0
+}
+
+func parseComparator(name string) (lsm_tree.Comparator, error) {
+	switch name {
+	case "bytewise":
+		return comparator.Bytewise, nil
+	case "reverse":
+		return comparator.Reverse(comparator.Bytewise), nil
+	}
+	return nil, fmt.Errorf("unknown comparator %q", name)
+}
+
+// withTree runs f on the tree and closes it, which flushes the writes of f.
+func (e *env) withTree(f func(tree *lsm_tree.LSMTree) error) error {
+	return e.openTree(lsm_tree.Options{Dir: e.dir, Comparator: e.cmp}, f)
+}
+
+// inspectTree runs f on the tree opened read-only, so inspecting a tree
+// leaves its directory as it was.
+func (e *env) inspectTree(f func(tree *lsm_tree.LSMTree) error) error {
+	return e.openTree(lsm_tree.Options{Dir: e.dir, Comparator: e.cmp, ReadOnly: true}, f)
+}
+
+func (e *env) openTree(opts lsm_tree.Options, f func(tree *lsm_tree.LSMTree) error) error {
+	tree, err := lsm_tree.Open(opts)
+	if err != nil {
+		return err
+	}
+
+	err = f(tree)
+	if closeErr := tree.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
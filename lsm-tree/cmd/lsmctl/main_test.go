@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hw1/internal/common"
+)
+
+func runLsmctl(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func expectRun(t *testing.T, expectedCode int, expectedStdout string, args ...string) {
+	t.Helper()
+
+	code, stdout, stderr := runLsmctl(t, args...)
+	if code != expectedCode || stdout != expectedStdout {
+		t.Fatalf("lsmctl %v: exit code %d, stdout %q, stderr %q; expected %d and %q",
+			args, code, stdout, stderr, expectedCode, expectedStdout)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	expectRun(t, 0, "", "-dir", dir, "put", "a", "1")
+	expectRun(t, 0, "", "-dir", dir, "put", "b")
+	expectRun(t, 0, "", "-dir", dir, "put", "c", "3")
+	expectRun(t, 0, "", "-dir", dir, "delete", "c")
+
+	expectRun(t, 0, "1\n", "-dir", dir, "get", "a")
+	expectRun(t, 1, "", "-dir", dir, "get", "c")
+	expectRun(t, 0, "a\t1\nb\t\n", "-dir", dir, "scan")
+	expectRun(t, 0, "b\t\na\t1\n", "-dir", dir, "scan", "-reverse")
+	expectRun(t, 0, "2\n", "-dir", dir, "scan", "-count", "a", "b")
+	expectRun(t, 0, "b\t\n", "-dir", dir, "scan", "-limit", "1", "b")
+
+	expectRun(t, 0, "", "-dir", dir, "compact")
+	code, stdout, _ := runLsmctl(t, "-dir", dir, "levels")
+	if code != 0 || !strings.HasPrefix(stdout, "level 0: 1 runs\n  run 0: 1 tables\n") {
+		t.Fatalf("levels: exit code %d, stdout %q", code, stdout)
+	}
+	expectRun(t, 0, "ok: 1 tables, 2 elements\n", "-dir", dir, "check")
+
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"-comparator", "unknown", "get", "a"},
+		{"-dir", dir, "get"},
+		{"-dir", dir, "scan", "a", "b", "c"},
+	} {
+		if code, _, _ = runLsmctl(t, args...); code != 2 {
+			t.Fatalf("lsmctl %v: exit code %d, expected 2", args, code)
+		}
+	}
+}
+
+func TestInspectionLeavesTreeUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	expectRun(t, 0, "", "-dir", dir, "put", "a", "1")
+
+	// A file left by an interrupted flush, which opening the tree for writes
+	// removes.
+	leftover := filepath.Join(dir, common.DataDir, "9"+common.TempSuffix)
+	if err := os.WriteFile(leftover, []byte("leftover"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRun(t, 0, "1\n", "-dir", dir, "get", "a")
+	expectRun(t, 0, "a\t1\n", "-dir", dir, "scan")
+	expectRun(t, 0, "ok: 1 tables, 1 elements\n", "-dir", dir, "check")
+	if code, _, _ := runLsmctl(t, "-dir", dir, "levels"); code != 0 {
+		t.Fatalf("levels: exit code %d", code)
+	}
+	if _, err := os.Stat(leftover); err != nil {
+		t.Fatalf("inspecting the tree removed %s: %v", leftover, err)
+	}
+
+	// Inspecting a missing tree doesn't create it.
+	missing := filepath.Join(dir, "missing")
+	expectRun(t, 1, "", "-dir", missing, "get", "a")
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatalf("inspecting %s created it: %v", missing, err)
+	}
+}
+
+func TestCheckCorruptedTable(t *testing.T) {
+	dir := t.TempDir()
+	expectRun(t, 0, "", "-dir", dir, "put", "a", "1")
+
+	dataPath := filepath.Join(dir, common.DataDir, "0")
+	if err := os.WriteFile(dataPath, []byte("garbage"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runLsmctl(t, "-dir", dir, "check")
+	metaPath := filepath.Join(dir, common.MetaDataDir, "0")
+	if code != 1 || !strings.HasPrefix(stdout, "corrupted: "+metaPath+": ") || !strings.Contains(stderr, "1 corrupted tables") {
+		t.Fatalf("check: exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,86 @@
+// Command lsmd serves a tree directory over the Redis protocol until it is
+// interrupted, then finishes the commands in progress and closes the tree.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/comparator"
+	"hw1/server"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "tree directory")
+	addr := flag.String("addr", "127.0.0.1:6380", "address to listen on")
+	cmpName := flag.String("comparator", "bytewise", "key order of the tree: bytewise or reverse")
+	idleTimeout := flag.Duration("idle-timeout", 0, "close connections idle for this long, 0 for never")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "time to finish the commands in progress on shutdown")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if err := run(*dir, *addr, *cmpName, *idleTimeout, *shutdownTimeout, logger); err != nil {
+		logger.Error("lsmd failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, addr string, cmpName string, idleTimeout time.Duration, shutdownTimeout time.Duration, logger *slog.Logger) (err error) {
+	var cmp lsm_tree.Comparator
+	switch cmpName {
+	case "bytewise":
+		cmp = comparator.Bytewise
+	case "reverse":
+		cmp = comparator.Reverse(comparator.Bytewise)
+	default:
+		return fmt.Errorf("unknown comparator %q", cmpName)
+	}
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{Dir: dir, Comparator: cmp, Logger: logger})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := tree.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("listening", "addr", l.Addr().String(), "dir", dir)
+
+	s := server.New(tree, server.Options{Logger: logger, IdleTimeout: idleTimeout})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = s.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("closed connections with unfinished commands", "error", err)
+	}
+	if err = <-served; !errors.Is(err, server.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -5,4 +5,8 @@ const (
 	FirstLevelSize = 50000
 	DataDir        = "./data"
 	MetaDataDir    = "./metadata"
+	LostDir        = "./lost"
+	ManifestFile   = "MANIFEST"
+	TempSuffix     = ".tmp"
+	TargetFileSize = 64 << 20
 )
@@ -0,0 +1,15 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+)
+
+// DiscardHandler drops every record; it's the default handler of the
+// loggers of the tree and the server.
+type DiscardHandler struct{}
+
+func (DiscardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (DiscardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h DiscardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h DiscardHandler) WithGroup(string) slog.Handler           { return h }
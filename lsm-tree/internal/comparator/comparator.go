@@ -0,0 +1,38 @@
+package comparator
+
+import "strings"
+
+// Compare must return 0 only for identical keys: the RAM component of the
+// tree relies on map equality. Name is persisted in every sstable.
+type Comparator interface {
+	Compare(a, b string) int
+	Name() string
+}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "bytewise"
+}
+
+var Bytewise Comparator = bytewiseComparator{}
+
+type reverseComparator struct {
+	base Comparator
+}
+
+func (r reverseComparator) Compare(a, b string) int {
+	return r.base.Compare(b, a)
+}
+
+func (r reverseComparator) Name() string {
+	return "reverse." + r.base.Name()
+}
+
+func Reverse(base Comparator) Comparator {
+	return reverseComparator{base: base}
+}
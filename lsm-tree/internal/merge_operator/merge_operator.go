@@ -0,0 +1,88 @@
+package merge_operator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidOperand  = errors.New("invalid merge operand")
+	ErrNoMergeOperator = errors.New("merge operands found, but no merge operator is set")
+)
+
+// MergeOperator folds operands written by Merge into values. Operands are
+// ordered from the oldest to the newest. PartialMerge must be associative
+// with FullMerge, as merges combine operands before the base value is known.
+type MergeOperator interface {
+	FullMerge(key string, existing string, exists bool, operands []string) (string, error)
+	PartialMerge(key string, older string, newer string) (string, error)
+	Name() string
+}
+
+// uint64Add stores counters as decimal strings and wraps around on overflow.
+type uint64Add struct{}
+
+func (uint64Add) FullMerge(key string, existing string, exists bool, operands []string) (string, error) {
+	var sum uint64
+	if exists {
+		value, err := parseUint64(existing)
+		if err != nil {
+			return "", err
+		}
+		sum = value
+	}
+
+	for _, operand := range operands {
+		value, err := parseUint64(operand)
+		if err != nil {
+			return "", err
+		}
+		sum += value
+	}
+
+	return strconv.FormatUint(sum, 10), nil
+}
+
+func (o uint64Add) PartialMerge(key string, older string, newer string) (string, error) {
+	return o.FullMerge(key, older, true, []string{newer})
+}
+
+func (uint64Add) Name() string {
+	return "uint64add"
+}
+
+func parseUint64(s string) (uint64, error) {
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidOperand, err)
+	}
+	return value, nil
+}
+
+var UInt64Add MergeOperator = uint64Add{}
+
+type stringAppend struct {
+	delimiter string
+}
+
+func (o stringAppend) FullMerge(key string, existing string, exists bool, operands []string) (string, error) {
+	if exists {
+		operands = append([]string{existing}, operands...)
+	}
+	return strings.Join(operands, o.delimiter), nil
+}
+
+func (o stringAppend) PartialMerge(key string, older string, newer string) (string, error) {
+	return older + o.delimiter + newer, nil
+}
+
+func (o stringAppend) Name() string {
+	return "stringappend"
+}
+
+// StringAppend joins the existing value and the operands with the delimiter.
+func StringAppend(delimiter string) MergeOperator {
+	return stringAppend{delimiter: delimiter}
+}
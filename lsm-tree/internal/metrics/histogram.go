@@ -0,0 +1,61 @@
+package metrics
+
+import "slices"
+
+// LatencyBuckets are upper bounds in seconds from 10µs to about 1s.
+var LatencyBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// Histogram counts observations in buckets with the given upper bounds, the
+// way Prometheus histograms do. It isn't safe for concurrent use.
+type Histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type Bucket struct {
+	UpperBound float64
+	// Count is cumulative: it includes the observations of smaller buckets.
+	Count uint64
+}
+
+type HistogramSnapshot struct {
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: slices.Clone(bounds),
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.count++
+	h.sum += value
+	idx, _ := slices.BinarySearch(h.bounds, value)
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		Buckets: make([]Bucket, len(h.bounds)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		snapshot.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+	return snapshot
+}
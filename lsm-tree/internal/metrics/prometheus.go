@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Label struct {
+	Name, Value string
+}
+
+// TextWriter writes metrics in the Prometheus text exposition format. The
+// first write error is kept and returned by Err, later writes are skipped.
+type TextWriter struct {
+	w   io.Writer
+	err error
+}
+
+func NewTextWriter(w io.Writer) *TextWriter {
+	return &TextWriter{w: w}
+}
+
+// Family starts a metric family; its samples must follow it.
+func (t *TextWriter) Family(name string, help string, kind string) {
+	t.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (t *TextWriter) Sample(name string, value float64, labels ...Label) {
+	t.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func (t *TextWriter) Histogram(name string, help string, h HistogramSnapshot) {
+	t.Family(name, help, "histogram")
+	for _, bucket := range h.Buckets {
+		t.Sample(name+"_bucket", float64(bucket.Count), Label{"le", formatValue(bucket.UpperBound)})
+	}
+	t.Sample(name+"_bucket", float64(h.Count), Label{"le", "+Inf"})
+	t.Sample(name+"_sum", h.Sum)
+	t.Sample(name+"_count", float64(h.Count))
+}
+
+func (t *TextWriter) Err() error {
+	return t.err
+}
+
+func (t *TextWriter) printf(format string, args ...any) {
+	if t.err != nil {
+		return
+	}
+	_, t.err = fmt.Fprintf(t.w, format, args...)
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = label.Name + "=" + strconv.Quote(label.Value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,290 @@
+// Package resp reads and writes the Redis serialization protocol: commands
+// are arrays of bulk strings, replies are simple strings, errors, integers,
+// bulk strings or arrays of replies.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxBulkLength and MaxArrayLength bound what a peer can make the
+	// reader allocate.
+	MaxBulkLength  = 64 << 20
+	MaxArrayLength = 1 << 20
+	maxLineLength  = 64 << 10
+)
+
+var ErrProtocol = errors.New("protocol error")
+
+type Kind byte
+
+const (
+	SimpleString Kind = '+'
+	Error        Kind = '-'
+	Integer      Kind = ':'
+	BulkString   Kind = '$'
+	Array        Kind = '*'
+)
+
+// Reply is a decoded reply. Str holds simple strings, errors and bulk
+// strings, Null marks the null bulk string and the null array.
+type Reply struct {
+	Kind  Kind
+	Str   string
+	Int   int64
+	Array []Reply
+	Null  bool
+}
+
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Buffered returns the number of bytes that can be read without blocking,
+// e.g. the rest of a pipeline.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// ReadCommand reads a command sent as an array of bulk strings or, as typed
+// into a terminal, as a line of space-separated words. Empty commands are
+// skipped.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		prefix, err := r.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		var args []string
+		if Kind(prefix[0]) == Array {
+			args, err = r.readCommandArray()
+		} else {
+			var line string
+			line, err = r.readLine()
+			args = strings.Fields(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+func (r *Reader) readCommandArray() ([]string, error) {
+	n, err := r.readLength(Array, MaxArrayLength)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("%w: null array as a command", ErrProtocol)
+	}
+
+	args := make([]string, 0, n)
+	for range n {
+		length, err := r.readLength(BulkString, MaxBulkLength)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("%w: null bulk string in a command", ErrProtocol)
+		}
+		arg, err := r.readBulk(length)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (r *Reader) ReadReply() (Reply, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Reply{}, err
+	}
+	if line == "" {
+		return Reply{}, fmt.Errorf("%w: empty reply", ErrProtocol)
+	}
+
+	reply := Reply{Kind: Kind(line[0])}
+	switch reply.Kind {
+	case SimpleString, Error:
+		reply.Str = line[1:]
+	case Integer:
+		reply.Int, err = strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return Reply{}, fmt.Errorf("%w: invalid integer %q", ErrProtocol, line[1:])
+		}
+	case BulkString:
+		length, err := parseLength(line[1:], MaxBulkLength)
+		if err != nil {
+			return Reply{}, err
+		}
+		if length < 0 {
+			reply.Null = true
+			break
+		}
+		if reply.Str, err = r.readBulk(length); err != nil {
+			return Reply{}, err
+		}
+	case Array:
+		n, err := parseLength(line[1:], MaxArrayLength)
+		if err != nil {
+			return Reply{}, err
+		}
+		if n < 0 {
+			reply.Null = true
+			break
+		}
+		reply.Array = make([]Reply, n)
+		for i := range reply.Array {
+			if reply.Array[i], err = r.ReadReply(); err != nil {
+				return Reply{}, err
+			}
+		}
+	default:
+		return Reply{}, fmt.Errorf("%w: unknown reply type %q", ErrProtocol, line[0])
+	}
+	return reply, nil
+}
+
+func (r *Reader) readLength(kind Kind, limit int) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	if line == "" || Kind(line[0]) != kind {
+		return 0, fmt.Errorf("%w: expected %q, got %q", ErrProtocol, kind, line)
+	}
+	return parseLength(line[1:], limit)
+}
+
+// parseLength accepts -1 for null and lengths up to limit.
+func parseLength(s string, limit int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 || n > limit {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, s)
+	}
+	return n, nil
+}
+
+func (r *Reader) readBulk(length int) (string, error) {
+	buf := make([]byte, length+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if string(buf[length:]) != "\r\n" {
+		return "", fmt.Errorf("%w: bulk string is not terminated by CRLF", ErrProtocol)
+	}
+	return string(buf[:length]), nil
+}
+
+// readLine reads a line terminated by CRLF, or by LF alone for inline
+// commands.
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if len(line) > 0 {
+				return "", unexpectedEOF(err)
+			}
+			return "", err
+		}
+		if len(line) > maxLineLength {
+			return "", fmt.Errorf("%w: line is too long", ErrProtocol)
+		}
+	}
+
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Writer buffers what it writes until Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) WriteCommand(args ...string) error {
+	w.WriteArrayHeader(len(args))
+	for _, arg := range args {
+		w.WriteBulk(arg)
+	}
+	return w.err()
+}
+
+// WriteSimple writes a simple string, which must not contain CR or LF.
+func (w *Writer) WriteSimple(s string) {
+	w.writeLine(SimpleString, s)
+}
+
+// WriteError writes an error with line breaks replaced by spaces.
+func (w *Writer) WriteError(message string) {
+	w.writeLine(Error, strings.NewReplacer("\r", " ", "\n", " ").Replace(message))
+}
+
+func (w *Writer) WriteInteger(n int64) {
+	w.writeLine(Integer, strconv.FormatInt(n, 10))
+}
+
+func (w *Writer) WriteBulk(s string) {
+	w.writeLine(BulkString, strconv.Itoa(len(s)))
+	_, _ = w.w.WriteString(s)
+	_, _ = w.w.WriteString("\r\n")
+}
+
+func (w *Writer) WriteNull() {
+	w.writeLine(BulkString, "-1")
+}
+
+// WriteArrayHeader starts an array of n replies, which are written next.
+func (w *Writer) WriteArrayHeader(n int) {
+	w.writeLine(Array, strconv.Itoa(n))
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeLine(kind Kind, s string) {
+	_ = w.w.WriteByte(byte(kind))
+	_, _ = w.w.WriteString(s)
+	_, _ = w.w.WriteString("\r\n")
+}
+
+// err returns the first error of the underlying writer, which bufio keeps
+// returning from every later write.
+func (w *Writer) err() error {
+	_, err := w.w.Write(nil)
+	return err
+}
@@ -5,9 +5,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
 )
 
+var metaSize = int64(binary.Size(meta{}))
+
 type meta struct {
 	offset int64
 	length int64
@@ -49,11 +50,10 @@ func metaFromBytes(reader io.Reader) (*meta, error) {
 	}, nil
 }
 
-func setMetaFileOffset(file *os.File, elementIdx int64) error {
-	offset := elementIdx * int64(binary.Size(meta{}))
-	_, err := file.Seek(offset, 0)
-	if err != nil {
-		return fmt.Errorf("%w: %w", ErrFileSeeking, err)
+func metaFromFile(metaFile io.ReaderAt, elementIdx int64) (*meta, error) {
+	buf := make([]byte, metaSize)
+	if _, err := metaFile.ReadAt(buf, elementIdx*metaSize); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
 	}
-	return nil
+	return metaFromBytes(bytes.NewReader(buf))
 }
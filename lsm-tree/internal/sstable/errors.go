@@ -5,12 +5,18 @@ import "errors"
 var (
 	ErrFileClosing     = errors.New("error closing file")
 	ErrFileCreating    = errors.New("failed to create file")
+	ErrFileOpening     = errors.New("failed to open file")
+	ErrFileRenaming    = errors.New("failed to rename file")
 	ErrFileSeeking     = errors.New("file seeking failed")
+	ErrFileSyncing     = errors.New("failed to sync file")
 	ErrReadingFromFile = errors.New("failed to read from file")
 	ErrSetFileOffset   = errors.New("failed to set file offset")
 	ErrWritingBytes    = errors.New("failed writing bytes value")
 
-	ErrBloomFilter    = errors.New("bloom filter error")
-	ErrMergingTables  = errors.New("error merging sstables")
-	ErrWritingElement = errors.New("error writing sstable element")
+	ErrBloomFilter        = errors.New("bloom filter error")
+	ErrComparatorMismatch = errors.New("sstable was written with a different comparator")
+	ErrCorruptedTable     = errors.New("sstable is corrupted")
+	ErrKeysOutOfOrder     = errors.New("sstable keys must be added in strictly increasing order")
+	ErrMergingTables      = errors.New("error merging sstables")
+	ErrWritingElement     = errors.New("error writing sstable element")
 )
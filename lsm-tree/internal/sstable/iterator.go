@@ -0,0 +1,141 @@
+package sstable
+
+import (
+	"bufio"
+	"io"
+)
+
+const reverseBatchSize = 256
+
+type Bound int
+
+const (
+	Inclusive Bound = iota
+	Exclusive
+	Unbounded
+)
+
+type RangeOptions struct {
+	LeftBound  Bound
+	RightBound Bound
+	Reverse    bool
+}
+
+// Iterator walks the elements of a single table with indexes in [L, R].
+// Forward iteration reads the files sequentially, reverse iteration reads
+// them backwards in batches. Neither moves the files' offsets.
+type Iterator struct {
+	table      *SSTable
+	left       int
+	right      int
+	next       int
+	reverse    bool
+	metaReader *bufio.Reader
+	dataReader *bufio.Reader
+	batch      []*TableElement
+}
+
+func (s *SSTable) NewIterator(keyL string, keyR string, opts RangeOptions) (*Iterator, error) {
+	L, R, err := s.Bounds(keyL, keyR, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.NewIndexIterator(L, R, opts.Reverse), nil
+}
+
+func (s *SSTable) NewIndexIterator(L int, R int, reverse bool) *Iterator {
+	it := &Iterator{table: s, left: L, right: R, reverse: reverse, next: L}
+	if reverse {
+		it.next = R
+	}
+	return it
+}
+
+// Len returns the number of elements the iterator has not returned yet.
+func (it *Iterator) Len() int {
+	if it.reverse {
+		return max(it.next-it.left+1, 0)
+	}
+	return max(it.right-it.next+1, 0)
+}
+
+func (it *Iterator) Next() (*TableElement, error) {
+	if it.Len() == 0 {
+		return nil, io.EOF
+	}
+
+	if it.reverse {
+		if len(it.batch) == 0 {
+			batch, err := readBatch(it.table.metaFile, it.table.dataFile, it.table.dataSize, max(it.left, it.next-reverseBatchSize+1), it.next)
+			if err != nil {
+				return nil, err
+			}
+			it.batch = batch
+		}
+		element := it.batch[len(it.batch)-1]
+		it.batch = it.batch[:len(it.batch)-1]
+		it.next--
+		return element, nil
+	}
+
+	if it.metaReader == nil {
+		var err error
+		it.metaReader, it.dataReader, err = consecutiveReaders(it.table.metaFile, it.table.dataFile, int64(it.next))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	element, err := tableElementFromFileConsecutive(it.metaReader, it.dataReader, it.table.dataSize)
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrCorruptedTable
+		}
+		return nil, err
+	}
+	it.next++
+	return element, nil
+}
+
+// Bounds returns the indexes of the first and the last element inside the
+// key range. The range is empty when L > R.
+func (s *SSTable) Bounds(keyL string, keyR string, opts RangeOptions) (int, int, error) {
+	L, R := 0, s.size-1
+
+	var err error
+	if opts.LeftBound != Unbounded {
+		L, err = s.firstIndex(keyL, opts.LeftBound == Exclusive)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if opts.RightBound != Unbounded {
+		R, err = s.firstIndex(keyR, opts.RightBound == Inclusive)
+		if err != nil {
+			return 0, 0, err
+		}
+		R--
+	}
+
+	return L, R, nil
+}
+
+// firstIndex returns the index of the first element greater than key when
+// strict is set, and the first element not less than key otherwise.
+func (s *SSTable) firstIndex(key string, strict bool) (int, error) {
+	left, right := -1, s.size
+	for right-left > 1 {
+		mid := (left + right) / 2
+		midKey, err := tableElementFromFileRandom(s.metaFile, s.dataFile, s.dataSize, int64(mid))
+		if err != nil {
+			return 0, err
+		}
+		cmpResult := s.cmp.Compare(midKey.Value, key)
+		if cmpResult < 0 || (strict && cmpResult == 0) {
+			left = mid
+		} else {
+			right = mid
+		}
+	}
+	return right, nil
+}
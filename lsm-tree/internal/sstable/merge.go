@@ -0,0 +1,335 @@
+package sstable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"hw1/internal/comparator"
+	"hw1/internal/merge_operator"
+	"hw1/internal/vfs"
+)
+
+type FilterDecision int
+
+const (
+	FilterKeep FilterDecision = iota
+	FilterRemove
+	FilterChangeValue
+)
+
+type MergeOptions struct {
+	DropTombstones bool
+	// A new output table is started once the current one reaches
+	// TargetFileSize bytes. Zero means a single output table.
+	TargetFileSize int64
+	// Subcompactions is the maximum number of disjoint key ranges merged in
+	// parallel. Zero or one disables splitting.
+	Subcompactions int
+	// Elements expired at Now, the Unix time in nanoseconds, are dropped like
+	// tombstones, or replaced with tombstones if those are kept. Zero keeps
+	// expired elements.
+	Now int64
+	// Filter is called for every element that survives the merge. Removed
+	// elements are handled like expired ones.
+	Filter func(key string, value string) (FilterDecision, string)
+	// MergeOperator folds merge operands with the older versions of their
+	// keys. Operands that can't be resolved yet are combined into one, unless
+	// tombstones are dropped: then the inputs hold every version of a key.
+	MergeOperator merge_operator.MergeOperator
+	// Progress is called periodically with the number of input elements
+	// merged so far. Calls are serialized even with subcompactions.
+	Progress func(elementsMerged int)
+}
+
+const (
+	progressInterval = 10000
+	// Smaller merges aren't split, goroutines would only add overhead.
+	minSubcompactionSize = 50000
+	// Boundaries of subcompactions are chosen among about that many sampled
+	// keys per subcompaction.
+	samplesPerSubcompaction = 16
+)
+
+// keyRange is the part of the key space merged by a single subcompaction.
+// Unbounded ends are used for the first and the last ranges.
+type keyRange struct {
+	left, right string
+	opts        RangeOptions
+}
+
+type merger struct {
+	fs             vfs.FS
+	tables         []*SSTable
+	cmp            comparator.Comparator
+	opts           MergeOptions
+	pathsMu        sync.Mutex
+	nextPaths      func() (string, string)
+	progressMu     sync.Mutex
+	elementsMerged atomic.Int64
+}
+
+// Merge merges tables ordered from the oldest to the newest into new tables
+// with disjoint key ranges, asking nextPaths for the file paths of each. The
+// output tables are ordered by key. It returns no tables if no element
+// survives. On failure or cancellation all the output files are removed.
+func Merge(ctx context.Context, fs vfs.FS, tablesToMerge []*SSTable, cmp comparator.Comparator, opts MergeOptions, nextPaths func() (string, string)) ([]*SSTable, error) {
+	totalSize := 0
+	for _, table := range tablesToMerge {
+		if table.cmp.Name() != cmp.Name() {
+			return nil, ErrComparatorMismatch
+		}
+		totalSize += table.size
+	}
+
+	m := &merger{
+		fs:        fs,
+		tables:    tablesToMerge,
+		cmp:       cmp,
+		opts:      opts,
+		nextPaths: nextPaths,
+	}
+
+	ranges, err := m.splitKeySpace(min(opts.Subcompactions, totalSize/minSubcompactionSize))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := m.mergeRanges(ctx, ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(int(m.elementsMerged.Load()))
+	}
+
+	return outputs, nil
+}
+
+// splitKeySpace picks up to n-1 boundary keys among keys sampled evenly from
+// the inputs, so that every range gets about the same number of elements.
+func (m *merger) splitKeySpace(n int) ([]keyRange, error) {
+	whole := keyRange{opts: RangeOptions{LeftBound: Unbounded, RightBound: Unbounded}}
+	if n <= 1 {
+		return []keyRange{whole}, nil
+	}
+
+	totalSize := 0
+	for _, table := range m.tables {
+		totalSize += table.size
+	}
+	stride := max(totalSize/(n*samplesPerSubcompaction), 1)
+
+	samples := make([]string, 0)
+	for _, table := range m.tables {
+		for idx := stride / 2; idx < table.size; idx += stride {
+			element, err := table.ElementAt(idx)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, element.Value)
+		}
+	}
+	slices.SortFunc(samples, m.cmp.Compare)
+
+	boundaries := make([]string, 0, n-1)
+	for i := 1; i < n; i++ {
+		boundary := samples[i*len(samples)/n]
+		if len(boundaries) == 0 || m.cmp.Compare(boundaries[len(boundaries)-1], boundary) < 0 {
+			boundaries = append(boundaries, boundary)
+		}
+	}
+
+	ranges := make([]keyRange, 0, len(boundaries)+1)
+	for i := range len(boundaries) + 1 {
+		r := keyRange{opts: RangeOptions{LeftBound: Inclusive, RightBound: Exclusive}}
+		if i == 0 {
+			r.opts.LeftBound = Unbounded
+		} else {
+			r.left = boundaries[i-1]
+		}
+		if i == len(boundaries) {
+			r.opts.RightBound = Unbounded
+		} else {
+			r.right = boundaries[i]
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// mergeRanges runs a goroutine per range and concatenates their outputs. The
+// first failure cancels the other subcompactions.
+func (m *merger) mergeRanges(ctx context.Context, ranges []keyRange) ([]*SSTable, error) {
+	if len(ranges) == 1 {
+		return m.mergeRange(ctx, ranges[0])
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([][]*SSTable, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = m.mergeRange(subCtx, r)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := ctx.Err()
+	for _, subErr := range errs {
+		if err == nil && subErr != nil && !errors.Is(subErr, context.Canceled) {
+			err = subErr
+		}
+	}
+	if err != nil {
+		for _, outputs := range results {
+			removeOutputs(outputs)
+		}
+		return nil, err
+	}
+
+	return slices.Concat(results...), nil
+}
+
+func (m *merger) mergeRange(ctx context.Context, r keyRange) ([]*SSTable, error) {
+	sizeEstimation := 0
+	inputs := make([]ElementIterator, 0, len(m.tables))
+	for _, table := range m.tables {
+		L, R, err := table.Bounds(r.left, r.right, r.opts)
+		if err != nil {
+			return nil, err
+		}
+		if L > R {
+			continue
+		}
+		sizeEstimation += R - L + 1
+		inputs = append(inputs, table.NewIndexIterator(L, R, false))
+	}
+
+	outputs := make([]*SSTable, 0)
+	var current *tableWriter
+	abort := func() {
+		if current != nil {
+			current.abort()
+		}
+		removeOutputs(outputs)
+	}
+
+	it := NewMergingIterator(inputs, m.cmp, false)
+	it.FoldOperands(m.opts.MergeOperator, m.opts.Now, m.opts.DropTombstones)
+	reported := 0
+	for {
+		element, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			abort()
+			return nil, err
+		}
+
+		if it.Consumed()-reported >= progressInterval {
+			m.reportProgress(it.Consumed() - reported)
+			reported = it.Consumed()
+			if err = ctx.Err(); err != nil {
+				abort()
+				return nil, err
+			}
+		}
+
+		if m.opts.Now != 0 && element.Expired(m.opts.Now) {
+			element = &TableElement{Value: element.Value, IsTombstone: true}
+		}
+		if m.opts.Filter != nil && !element.IsTombstone && !element.IsMergeOperand {
+			decision, value := m.opts.Filter(element.Value, element.Data)
+			switch decision {
+			case FilterRemove:
+				element = &TableElement{Value: element.Value, IsTombstone: true}
+			case FilterChangeValue:
+				changed := *element
+				changed.Data = value
+				element = &changed
+			}
+		}
+		if m.opts.DropTombstones && element.IsTombstone {
+			continue
+		}
+
+		if current == nil {
+			metaPath, dataPath := m.newTablePaths()
+			current, err = newTableWriter(m.fs, metaPath, dataPath, m.cmp, sizeEstimation)
+			if err != nil {
+				abort()
+				return nil, err
+			}
+		}
+
+		if err = current.add(element); err != nil {
+			abort()
+			return nil, fmt.Errorf("%w: %w", ErrWritingElement, err)
+		}
+
+		if m.opts.TargetFileSize > 0 && current.fileSize() >= m.opts.TargetFileSize {
+			table, err := current.close()
+			current = nil
+			if err != nil {
+				abort()
+				return nil, err
+			}
+			outputs = append(outputs, table)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		abort()
+		return nil, err
+	}
+
+	if current != nil {
+		table, err := current.close()
+		current = nil
+		if err != nil {
+			abort()
+			return nil, err
+		}
+		outputs = append(outputs, table)
+	}
+
+	m.elementsMerged.Add(int64(it.Consumed() - reported))
+	return outputs, nil
+}
+
+func (m *merger) newTablePaths() (string, string) {
+	m.pathsMu.Lock()
+	defer m.pathsMu.Unlock()
+	return m.nextPaths()
+}
+
+func (m *merger) reportProgress(elements int) {
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+	elementsMerged := m.elementsMerged.Add(int64(elements))
+	if m.opts.Progress != nil {
+		m.opts.Progress(int(elementsMerged))
+	}
+}
+
+func removeOutputs(tables []*SSTable) {
+	for _, table := range tables {
+		_ = table.Remove()
+	}
+}
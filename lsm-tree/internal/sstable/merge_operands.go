@@ -0,0 +1,55 @@
+package sstable
+
+import (
+	"slices"
+
+	"hw1/internal/merge_operator"
+)
+
+// ResolveOperands folds merge operands of the key, ordered from the newest to
+// the oldest, over base, the newest older version of the key, or nil if there
+// is none. Unless complete, older versions may exist elsewhere, so operands
+// without a base are combined into a single operand instead.
+func ResolveOperands(op merge_operator.MergeOperator, key string, operands []string, base *TableElement, now int64, complete bool) (*TableElement, error) {
+	if base == nil && !complete {
+		if len(operands) == 1 {
+			return &TableElement{Value: key, Data: operands[0], IsMergeOperand: true}, nil
+		}
+		if op == nil {
+			return nil, merge_operator.ErrNoMergeOperator
+		}
+
+		combined := operands[len(operands)-1]
+		for i := len(operands) - 2; i >= 0; i-- {
+			var err error
+			combined, err = op.PartialMerge(key, combined, operands[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &TableElement{Value: key, Data: combined, IsMergeOperand: true}, nil
+	}
+
+	if op == nil {
+		return nil, merge_operator.ErrNoMergeOperator
+	}
+
+	exists := base != nil && !base.IsTombstone && !(now != 0 && base.Expired(now))
+	existing := ""
+	if exists {
+		existing = base.Data
+	}
+	oldestFirst := slices.Clone(operands)
+	slices.Reverse(oldestFirst)
+
+	value, err := op.FullMerge(key, existing, exists, oldestFirst)
+	if err != nil {
+		return nil, err
+	}
+
+	element := &TableElement{Value: key, Data: value}
+	if exists {
+		element.ExpiresAt = base.ExpiresAt
+	}
+	return element, nil
+}
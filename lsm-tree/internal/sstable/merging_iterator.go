@@ -0,0 +1,124 @@
+package sstable
+
+import (
+	"container/heap"
+	"io"
+
+	"hw1/internal/comparator"
+	"hw1/internal/merge_operator"
+)
+
+// ElementIterator returns io.EOF after the last element.
+type ElementIterator interface {
+	Next() (*TableElement, error)
+}
+
+// MergingIterator merges inputs ordered from the oldest to the newest and
+// returns only the newest version of every key, tombstones included. Inputs
+// may be empty.
+type MergingIterator struct {
+	inputs   []ElementIterator
+	queue    priorityQueue
+	started  bool
+	consumed int
+	last     string
+	hasLast  bool
+
+	foldOperands  bool
+	mergeOperator merge_operator.MergeOperator
+	now           int64
+	complete      bool
+}
+
+func NewMergingIterator(inputs []ElementIterator, cmp comparator.Comparator, reverse bool) *MergingIterator {
+	return &MergingIterator{
+		inputs: inputs,
+		queue:  priorityQueue{cmp: cmp, reverse: reverse},
+	}
+}
+
+func (m *MergingIterator) Next() (*TableElement, error) {
+	if !m.started {
+		m.started = true
+		heap.Init(&m.queue)
+		for i := range m.inputs {
+			if err := m.pushNext(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for m.queue.Len() > 0 {
+		item := heap.Pop(&m.queue).(*mergeItem)
+		if err := m.pushNext(item.readerIdx); err != nil {
+			return nil, err
+		}
+
+		if m.hasLast && m.queue.cmp.Compare(m.last, item.value.Value) == 0 {
+			continue
+		}
+		m.last, m.hasLast = item.value.Value, true
+
+		if m.foldOperands && item.value.IsMergeOperand {
+			return m.fold(item.value)
+		}
+		return &item.value, nil
+	}
+
+	return nil, io.EOF
+}
+
+// FoldOperands makes Next return merge operands folded with the older
+// versions of their keys. Complete means the inputs hold every version of
+// the keys, so operands are always resolved into values.
+func (m *MergingIterator) FoldOperands(op merge_operator.MergeOperator, now int64, complete bool) {
+	m.foldOperands = true
+	m.mergeOperator = op
+	m.now = now
+	m.complete = complete
+}
+
+// fold collects the operands of the key down to the first other version.
+// The versions of a key are popped newest first, so they are on the top of
+// the queue.
+func (m *MergingIterator) fold(newest TableElement) (*TableElement, error) {
+	operands := []string{newest.Data}
+	var base *TableElement
+	for base == nil && m.queue.Len() > 0 && m.queue.cmp.Compare(m.queue.items[0].value.Value, newest.Value) == 0 {
+		item := heap.Pop(&m.queue).(*mergeItem)
+		if err := m.pushNext(item.readerIdx); err != nil {
+			return nil, err
+		}
+
+		if item.value.IsMergeOperand {
+			operands = append(operands, item.value.Data)
+		} else {
+			base = &item.value
+		}
+	}
+
+	return ResolveOperands(m.mergeOperator, newest.Value, operands, base, m.now, m.complete)
+}
+
+// Consumed returns the number of elements read from the inputs so far,
+// shadowed versions included.
+func (m *MergingIterator) Consumed() int {
+	return m.consumed
+}
+
+func (m *MergingIterator) pushNext(readerIdx int) error {
+	element, err := m.inputs[readerIdx].Next()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.consumed++
+	heap.Push(&m.queue, &mergeItem{
+		value:     *element,
+		readerIdx: readerIdx,
+	})
+	return nil
+}
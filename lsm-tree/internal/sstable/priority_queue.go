@@ -1,34 +1,46 @@
 package sstable
 
+import "hw1/internal/comparator"
+
 type mergeItem struct {
 	value     TableElement
 	readerIdx int
 }
 
-type priorityQueue []*mergeItem
+// priorityQueue pops elements in the iteration order, and elements with
+// equal keys from the newest reader first.
+type priorityQueue struct {
+	items   []*mergeItem
+	cmp     comparator.Comparator
+	reverse bool
+}
 
-func (pq priorityQueue) Len() int { return len(pq) }
+func (pq *priorityQueue) Len() int { return len(pq.items) }
 
-func (pq priorityQueue) Less(i, j int) bool {
-	if pq[i].value.Value < pq[j].value.Value {
+func (pq *priorityQueue) Less(i, j int) bool {
+	cmpResult := pq.cmp.Compare(pq.items[i].value.Value, pq.items[j].value.Value)
+	if pq.reverse {
+		cmpResult = -cmpResult
+	}
+	if cmpResult < 0 {
 		return true
 	}
-	return pq[i].value.Value == pq[j].value.Value && pq[i].readerIdx > pq[j].readerIdx
+	return cmpResult == 0 && pq.items[i].readerIdx > pq.items[j].readerIdx
 }
 
-func (pq priorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
+func (pq *priorityQueue) Swap(i, j int) {
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
 }
 
 func (pq *priorityQueue) Push(x interface{}) {
 	element := x.(*mergeItem)
-	*pq = append(*pq, element)
+	pq.items = append(pq.items, element)
 }
 
 func (pq *priorityQueue) Pop() interface{} {
-	old := *pq
+	old := pq.items
 	n := len(old)
 	element := old[n-1]
-	*pq = old[0 : n-1]
+	pq.items = old[0 : n-1]
 	return element
 }
@@ -0,0 +1,148 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"hw1/internal/vfs"
+)
+
+const footerMagic uint64 = 0x4c534d5353544142
+
+type properties struct {
+	comparatorName string
+	size           int64
+	tombstones     int64
+	// expiring is missing in tables written before the expiry existed.
+	expiring int64
+	// The CRC-32C checksums of the index and of the data file are missing in
+	// tables written before checksums existed.
+	hasChecksums  bool
+	indexChecksum uint32
+	dataChecksum  uint32
+}
+
+func (p *properties) toBytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	if err := binary.Write(buf, binary.LittleEndian, int64(len(p.comparatorName))); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if _, err := buf.WriteString(p.comparatorName); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, p.size); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, p.tombstones); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, p.expiring); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, [2]uint32{p.indexChecksum, p.dataChecksum}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// propertiesFromBytes reads properties encoded in length bytes.
+func propertiesFromBytes(reader io.Reader, length int64) (*properties, error) {
+	var nameLength int64
+	if err := binary.Read(reader, binary.LittleEndian, &nameLength); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	if nameLength < 0 || nameLength > length-8 {
+		return nil, fmt.Errorf("%w: comparator name length %d overruns the properties", ErrCorruptedTable, nameLength)
+	}
+
+	name := make([]byte, nameLength)
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+
+	p := &properties{comparatorName: string(name)}
+	if err := binary.Read(reader, binary.LittleEndian, &p.size); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &p.tombstones); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &p.expiring); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	var checksums [2]uint32
+	if err := binary.Read(reader, binary.LittleEndian, &checksums); err == nil {
+		p.hasChecksums = true
+		p.indexChecksum, p.dataChecksum = checksums[0], checksums[1]
+	} else if err != io.EOF {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+
+	return p, nil
+}
+
+// The meta file ends with a footer: encoded properties, their length and a
+// magic number. Index records stay fixed-size, so the record of an element is
+// still found at its index times metaSize.
+func writeFooter(writer io.Writer, p *properties) error {
+	propertiesBytes, err := p.toBytes()
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(propertiesBytes)
+	if err = binary.Write(buf, binary.LittleEndian, int64(len(propertiesBytes))); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if err = binary.Write(buf, binary.LittleEndian, footerMagic); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+func readFooter(metaFile vfs.File) (*properties, error) {
+	fileSize, err := metaFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+
+	trailerSize := int64(binary.Size(int64(0)) + binary.Size(footerMagic))
+	if fileSize < trailerSize {
+		return nil, ErrCorruptedTable
+	}
+	if _, err = metaFile.Seek(fileSize-trailerSize, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+
+	var propertiesLength int64
+	var magic uint64
+	if err = binary.Read(metaFile, binary.LittleEndian, &propertiesLength); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	if err = binary.Read(metaFile, binary.LittleEndian, &magic); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	if magic != footerMagic || propertiesLength < 0 || propertiesLength > fileSize-trailerSize {
+		return nil, ErrCorruptedTable
+	}
+
+	indexSize := fileSize - trailerSize - propertiesLength
+	if _, err = metaFile.Seek(indexSize, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+	p, err := propertiesFromBytes(io.LimitReader(metaFile, propertiesLength), propertiesLength)
+	if err != nil {
+		return nil, err
+	}
+	if p.size < 0 || p.size*metaSize != indexSize {
+		return nil, ErrCorruptedTable
+	}
+
+	return p, nil
+}
@@ -0,0 +1,24 @@
+package sstable
+
+// ReadStats breaks down the work of point reads. A table is skipped by its
+// key range, or by its bloom filter, or searched with binary search probes;
+// a search that finds no key is a bloom filter false positive.
+type ReadStats struct {
+	TablesConsidered     int
+	TablesSkippedByRange int
+	BloomNegatives       int
+	BloomFalsePositives  int
+	Probes               int
+	// BytesRead counts the index entries and records read from the table
+	// files.
+	BytesRead int64
+}
+
+func (r *ReadStats) Add(other ReadStats) {
+	r.TablesConsidered += other.TablesConsidered
+	r.TablesSkippedByRange += other.TablesSkippedByRange
+	r.BloomNegatives += other.BloomNegatives
+	r.BloomFalsePositives += other.BloomFalsePositives
+	r.Probes += other.Probes
+	r.BytesRead += other.BytesRead
+}
@@ -2,15 +2,19 @@ package sstable
 
 import (
 	"bufio"
-	"container/heap"
+	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"os"
+	"io/fs"
 	"path/filepath"
 	"sort"
 
 	"hw1/internal/bloom_filter"
 	"hw1/internal/common"
+	"hw1/internal/comparator"
+	"hw1/internal/vfs"
 )
 
 type SearchResult int
@@ -22,66 +26,60 @@ const (
 )
 
 type SSTable struct {
-	metaFile    *os.File
-	dataFile    *os.File
-	size        int
-	bloomFilter bloom_filter.BloomFilter
+	fs         vfs.FS
+	metaPath   string
+	dataPath   string
+	metaFile   vfs.File
+	dataFile   vfs.File
+	size       int
+	tombstones int
+	expiring   int
+	fileSize   int64
+	dataSize   int64
+	// Checksums of the records written so far, only maintained while the
+	// table is being written.
+	indexChecksum uint32
+	dataChecksum  uint32
+	smallest      string
+	largest       string
+	bloomFilter   bloom_filter.BloomFilter
+	cmp           comparator.Comparator
+	closed        bool
 }
 
-func New(metaFilepath string, dataFilepath string, tablesToMerge []*SSTable) (*SSTable, error) {
-	if len(tablesToMerge) != common.MaxLevelSize {
-		return nil, fmt.Errorf("number of tables to merge is not equal to level size")
-	}
-
-	sizeEstimation := 0
-	for _, table := range tablesToMerge {
-		sizeEstimation += table.size
-	}
-
-	s := &SSTable{bloomFilter: bloom_filter.New(sizeEstimation)}
-
-	var err error
-	s.metaFile, err = createFile(metaFilepath)
+// New merges any number of tables, ordered from the oldest to the newest,
+// into a single new one.
+func New(fs vfs.FS, metaFilepath string, dataFilepath string, tablesToMerge []*SSTable, cmp comparator.Comparator) (*SSTable, error) {
+	tables, err := Merge(context.Background(), fs, tablesToMerge, cmp, MergeOptions{}, func() (string, string) {
+		return metaFilepath, dataFilepath
+	})
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+		return nil, fmt.Errorf("%w: %w", ErrMergingTables, err)
 	}
-
-	s.dataFile, err = createFile(dataFilepath)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+	if len(tables) == 1 {
+		return tables[0], nil
 	}
 
-	err = s.merge(tablesToMerge)
+	w, err := newTableWriter(fs, metaFilepath, dataFilepath, cmp, 0)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrMergingTables, err)
+		return nil, err
 	}
-
-	return s, nil
+	return w.close()
 }
 
-func NewFromMap(metaFilepath string, dataFilepath string, valuesToAdd map[string]struct{}, valuesToDelete map[string]struct{}) (*SSTable, error) {
-	s := &SSTable{bloomFilter: bloom_filter.New(common.FirstLevelSize)}
-
-	var err error
-
-	s.metaFile, err = createFile(metaFilepath)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
-	}
-	metaWriter := bufio.NewWriter(s.metaFile)
-	defer metaWriter.Flush()
-
-	s.dataFile, err = createFile(dataFilepath)
+// NewFromMap writes a table from the RAM component: valuesToAdd maps keys to
+// their elements.
+func NewFromMap(fs vfs.FS, metaFilepath string, dataFilepath string, valuesToAdd map[string]TableElement, valuesToDelete map[string]struct{}, cmp comparator.Comparator) (*SSTable, error) {
+	w, err := newTableWriter(fs, metaFilepath, dataFilepath, cmp, common.FirstLevelSize)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileCreating, err)
+		return nil, err
 	}
-	dataWriter := bufio.NewWriter(s.dataFile)
-	defer dataWriter.Flush()
 
 	valuesSorted := make([]TableElement, len(valuesToAdd)+len(valuesToDelete))
 	i := 0
-	for value := range valuesToAdd {
-		valuesSorted[i] = TableElement{Value: value}
+	for value, element := range valuesToAdd {
+		valuesSorted[i] = element
+		valuesSorted[i].Value = value
 		i++
 	}
 	for value := range valuesToDelete {
@@ -89,102 +87,211 @@ func NewFromMap(metaFilepath string, dataFilepath string, valuesToAdd map[string
 		i++
 	}
 	sort.Slice(valuesSorted, func(i, j int) bool {
-		return valuesSorted[i].Value < valuesSorted[j].Value
+		return cmp.Compare(valuesSorted[i].Value, valuesSorted[j].Value) < 0
 	})
 
-	offset := 0
 	for _, value := range valuesSorted {
-		err = s.writeElement(metaWriter, dataWriter, &value, &offset)
+		err = w.add(&value)
 		if err != nil {
+			w.abort()
 			return nil, err
 		}
 	}
 
+	return w.close()
+}
+
+func Open(fs vfs.FS, metaFilepath string, dataFilepath string, cmp comparator.Comparator) (*SSTable, error) {
+	s := &SSTable{fs: fs, metaPath: metaFilepath, dataPath: dataFilepath, cmp: cmp}
+
+	var err error
+	s.metaFile, err = fs.Open(metaFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+
+	s.dataFile, err = fs.Open(dataFilepath)
+	if err != nil {
+		_ = s.metaFile.Close()
+		return nil, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+
+	err = s.load()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
 	return s, nil
 }
 
+func (s *SSTable) Size() int {
+	return s.size
+}
+
+func (s *SSTable) Tombstones() int {
+	return s.tombstones
+}
+
+// Expiring returns the number of elements with an expiry time.
+func (s *SSTable) Expiring() int {
+	return s.expiring
+}
+
+// FileSize returns the number of bytes the table files occupy.
+func (s *SSTable) FileSize() int64 {
+	return s.fileSize
+}
+
+// Smallest and Largest are meaningless for an empty table.
+func (s *SSTable) Smallest() string {
+	return s.smallest
+}
+
+func (s *SSTable) Largest() string {
+	return s.largest
+}
+
 func (s *SSTable) SearchKey(key string) (SearchResult, error) {
-	if ok, err := s.bloomFilter.CheckContains([]byte(key)); err != nil {
-		return SearchResultNotFound, fmt.Errorf("%w: %w", ErrBloomFilter, err)
-	} else if !ok {
+	return s.SearchKeyWithStats(key, &ReadStats{})
+}
+
+// SearchKeyWithStats is SearchKey that adds its work to stats.
+func (s *SSTable) SearchKeyWithStats(key string, stats *ReadStats) (SearchResult, error) {
+	element, err := s.GetWithStats(key, stats)
+	if err != nil {
+		return SearchResultNotFound, err
+	}
+	if element == nil {
 		return SearchResultNotFound, nil
 	}
+	if element.IsTombstone {
+		return SearchResultRemoved, nil
+	}
+	return SearchResultFound, nil
+}
 
-	left, right := -1, s.size
-	for right-left > 1 {
-		mid := (left + right) / 2
-		midKey, err := tableElementFromFileRandom(s.metaFile, s.dataFile, int64(mid))
-		if err != nil {
-			return SearchResultNotFound, err
-		}
-		if midKey.Value == key {
-			if !midKey.IsTombstone {
-				return SearchResultFound, nil
-			} else {
-				return SearchResultRemoved, nil
-			}
-		} else if midKey.Value < key {
-			left = mid
-		} else {
-			right = mid
-		}
+// MayContain checks the key against the bloom filter only, so it may return
+// true for absent keys.
+func (s *SSTable) MayContain(key string) (bool, error) {
+	ok, err := s.bloomFilter.CheckContains([]byte(key))
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrBloomFilter, err)
 	}
+	return ok, nil
+}
 
-	return SearchResultNotFound, nil
+// Get returns the element with the key, or nil if the table has none.
+func (s *SSTable) Get(key string) (*TableElement, error) {
+	return s.GetWithStats(key, &ReadStats{})
 }
 
-func (s *SSTable) SearchRange(keyL string, keyR string) ([]*TableElement, error) {
-	left, right := -1, s.size
-	for right-left > 1 {
-		mid := (left + right) / 2
-		midKey, err := tableElementFromFileRandom(s.metaFile, s.dataFile, int64(mid))
-		if err != nil {
-			return nil, err
-		}
-		if midKey.Value < keyL {
-			left = mid
-		} else {
-			right = mid
-		}
+// GetWithStats is Get that adds its work to stats.
+func (s *SSTable) GetWithStats(key string, stats *ReadStats) (*TableElement, error) {
+	stats.TablesConsidered++
+	if s.cmp.Compare(key, s.smallest) < 0 || s.cmp.Compare(key, s.largest) > 0 {
+		stats.TablesSkippedByRange++
+		return nil, nil
+	}
+	if ok, err := s.MayContain(key); err != nil {
+		return nil, err
+	} else if !ok {
+		stats.BloomNegatives++
+		return nil, nil
 	}
-	L := left
 
-	left, right = -1, s.size
+	left, right := -1, s.size
 	for right-left > 1 {
 		mid := (left + right) / 2
-		midKey, err := tableElementFromFileRandom(s.metaFile, s.dataFile, int64(mid))
+		midKey, err := tableElementFromFileRandom(s.metaFile, s.dataFile, s.dataSize, int64(mid))
 		if err != nil {
 			return nil, err
 		}
-		if midKey.Value <= keyR {
+		stats.Probes++
+		stats.BytesRead += metaSize + midKey.EncodedSize()
+		cmpResult := s.cmp.Compare(midKey.Value, key)
+		if cmpResult == 0 {
+			return midKey, nil
+		} else if cmpResult < 0 {
 			left = mid
 		} else {
 			right = mid
 		}
 	}
-	R := left
 
-	_, err := setDataFileOffset(s.metaFile, s.dataFile, int64(L), true)
+	stats.BloomFalsePositives++
+	return nil, nil
+}
+
+func (s *SSTable) SearchRange(keyL string, keyR string) ([]*TableElement, error) {
+	it, err := s.NewIterator(keyL, keyR, RangeOptions{})
 	if err != nil {
 		return nil, err
 	}
-	metaReader := bufio.NewReader(s.metaFile)
-	dataReader := bufio.NewReader(s.dataFile)
 
-	result := make([]*TableElement, R-L+1)
-	for i := 0; i < R-L+1; i++ {
-		element, err := tableElementFromFileConsecutive(metaReader, dataReader)
+	result := make([]*TableElement, it.Len())
+	for i := range result {
+		result[i], err = it.Next()
 		if err != nil {
 			return nil, err
 		}
-
-		result[i] = element
 	}
 
 	return result, nil
 }
 
+func (s *SSTable) ElementAt(idx int) (*TableElement, error) {
+	if idx < 0 || idx >= s.size {
+		return nil, fmt.Errorf("element index %d is out of range", idx)
+	}
+	return tableElementFromFileRandom(s.metaFile, s.dataFile, s.dataSize, int64(idx))
+}
+
+// ApproximateRange returns the number of elements inside the key range and
+// the number of bytes they occupy on disk, tombstones included.
+func (s *SSTable) ApproximateRange(keyL string, keyR string) (int, int64, error) {
+	L, R, err := s.Bounds(keyL, keyR, RangeOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	if L > R {
+		return 0, 0, nil
+	}
+
+	first, err := metaFromFile(s.metaFile, int64(L))
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := metaFromFile(s.metaFile, int64(R))
+	if err != nil {
+		return 0, 0, err
+	}
+	// Records are variable-sized, so the size of the last one is only known
+	// from its content.
+	lastElement, err := tableElementFromFileRandom(s.metaFile, s.dataFile, s.dataSize, int64(R))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := R - L + 1
+	dataSize := last.offset + lastElement.EncodedSize() - first.offset
+	return count, dataSize + int64(count)*metaSize, nil
+}
+
+func (s *SSTable) Sync() error {
+	err := s.metaFile.Sync()
+	if err != nil {
+		return err
+	}
+	return s.dataFile.Sync()
+}
+
 func (s *SSTable) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
 	err := s.metaFile.Close()
 	if err != nil {
 		return fmt.Errorf("%w: %w", ErrFileClosing, err)
@@ -204,12 +311,12 @@ func (s *SSTable) Remove() error {
 		return err
 	}
 
-	err = os.Remove(s.metaFile.Name())
+	err = s.fs.Remove(s.metaPath)
 	if err != nil {
 		return err
 	}
 
-	err = os.Remove(s.dataFile.Name())
+	err = s.fs.Remove(s.dataPath)
 	if err != nil {
 		return err
 	}
@@ -217,62 +324,12 @@ func (s *SSTable) Remove() error {
 	return nil
 }
 
-func (s *SSTable) merge(tablesToMerge []*SSTable) error {
-	queue := priorityQueue{}
-	heap.Init(&queue)
-
-	metaWriter := bufio.NewWriter(s.metaFile)
-	defer metaWriter.Flush()
-	dataWriter := bufio.NewWriter(s.dataFile)
-	defer dataWriter.Flush()
-
-	metaReaders := make([]*bufio.Reader, len(tablesToMerge))
-	dataReaders := make([]*bufio.Reader, len(tablesToMerge))
-
-	for i := 0; i < len(tablesToMerge); i++ {
-		if _, err := setDataFileOffset(tablesToMerge[i].metaFile, tablesToMerge[i].dataFile, 0, true); err != nil {
-			return err
-		}
-		metaReaders[i] = bufio.NewReader(tablesToMerge[i].metaFile)
-		dataReaders[i] = bufio.NewReader(tablesToMerge[i].dataFile)
-
-		element, err := tableElementFromFileConsecutive(metaReaders[i], dataReaders[i])
-		if err != nil {
-			return err
-		}
-		heap.Push(&queue, &mergeItem{
-			value:     *element,
-			readerIdx: i,
-		})
-	}
-
-	var lastInserted string
-	offset := 0
-	for queue.Len() > 0 {
-		element := heap.Pop(&queue).(*mergeItem)
-
-		if offset == 0 || lastInserted != element.value.Value {
-			err := s.writeElement(metaWriter, dataWriter, &element.value, &offset)
-			if err != nil {
-				return fmt.Errorf("%w: %w", ErrWritingElement, err)
-			}
-		}
-
-		newElement, err := tableElementFromFileConsecutive(metaReaders[element.readerIdx], dataReaders[element.readerIdx])
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if err != io.EOF {
-			heap.Push(&queue, &mergeItem{
-				value:     *newElement,
-				readerIdx: element.readerIdx,
-			})
-		}
-
-		lastInserted = element.value.Value
-	}
+func (s *SSTable) MetaPath() string {
+	return s.metaPath
+}
 
-	return nil
+func (s *SSTable) DataPath() string {
+	return s.dataPath
 }
 
 func (s *SSTable) writeElement(metaDataWriter *bufio.Writer, dataWriter *bufio.Writer, element *TableElement, offset *int) error {
@@ -283,10 +340,11 @@ func (s *SSTable) writeElement(metaDataWriter *bufio.Writer, dataWriter *bufio.W
 	if _, err = dataWriter.Write(elementBytes); err != nil {
 		return err
 	}
+	s.dataChecksum = crc32.Update(s.dataChecksum, crc32cTable, elementBytes)
 
 	elementMetaData := meta{
 		offset: int64(*offset),
-		length: int64(len(elementBytes) - 1),
+		length: int64(len(element.Value)),
 	}
 	elementMetaDataBytes, err := elementMetaData.toBytes()
 	if err != nil {
@@ -296,21 +354,162 @@ func (s *SSTable) writeElement(metaDataWriter *bufio.Writer, dataWriter *bufio.W
 	if err != nil {
 		return err
 	}
+	s.indexChecksum = crc32.Update(s.indexChecksum, crc32cTable, elementMetaDataBytes)
 
 	err = s.bloomFilter.Add([]byte(element.Value))
 	if err != nil {
 		return fmt.Errorf("%w: %w", ErrBloomFilter, err)
 	}
 
+	if s.size == 0 {
+		s.smallest = element.Value
+	}
+	s.largest = element.Value
 	s.size++
+	if element.IsTombstone {
+		s.tombstones++
+	}
+	if element.ExpiresAt != 0 {
+		s.expiring++
+	}
 	*offset += len(elementBytes)
 
 	return nil
 }
 
-func createFile(path string) (*os.File, error) {
-	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+func (s *SSTable) finish(metaWriter *bufio.Writer, dataWriter *bufio.Writer) error {
+	err := writeFooter(metaWriter, &properties{
+		comparatorName: s.cmp.Name(),
+		size:           int64(s.size),
+		tombstones:     int64(s.tombstones),
+		expiring:       int64(s.expiring),
+		hasChecksums:   true,
+		indexChecksum:  s.indexChecksum,
+		dataChecksum:   s.dataChecksum,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+
+	if err = metaWriter.Flush(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+	if err = dataWriter.Flush(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingBytes, err)
+	}
+
+	return s.measureFiles()
+}
+
+func (s *SSTable) measureFiles() error {
+	metaFileSize, err := s.metaFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+	s.dataSize, err = s.dataFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+	s.fileSize = metaFileSize + s.dataSize
+	return nil
+}
+
+func (s *SSTable) load() error {
+	props, err := readFooter(s.metaFile)
+	if err != nil {
+		return err
+	}
+	if props.comparatorName != s.cmp.Name() {
+		return fmt.Errorf("%w: table uses %q, got %q", ErrComparatorMismatch, props.comparatorName, s.cmp.Name())
+	}
+
+	s.size = int(props.size)
+	s.tombstones = int(props.tombstones)
+	s.expiring = int(props.expiring)
+	if err = s.measureFiles(); err != nil {
+		return err
+	}
+	s.bloomFilter = bloom_filter.New(max(s.size, 1))
+
+	it := s.NewIndexIterator(0, s.size-1, false)
+	for i := 0; it.Len() > 0; i++ {
+		element, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			s.smallest = element.Value
+		} else if s.cmp.Compare(s.largest, element.Value) >= 0 {
+			return fmt.Errorf("%w: key %d is out of order", ErrCorruptedTable, i)
+		}
+		s.largest = element.Value
+		if err = s.bloomFilter.Add([]byte(element.Value)); err != nil {
+			return fmt.Errorf("%w: %w", ErrBloomFilter, err)
+		}
+	}
+
+	return nil
+}
+
+// create opens temporary files for the table; commit moves them under the
+// final names, so a table either exists completely or doesn't at all.
+func (s *SSTable) create() error {
+	var err error
+	s.metaFile, err = createFile(s.fs, s.metaPath+common.TempSuffix)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFileCreating, err)
+	}
+
+	s.dataFile, err = createFile(s.fs, s.dataPath+common.TempSuffix)
+	if err != nil {
+		_ = s.metaFile.Close()
+		return fmt.Errorf("%w: %w", ErrFileCreating, err)
+	}
+
+	return nil
+}
+
+func (s *SSTable) commit() error {
+	err := s.Sync()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFileSyncing, err)
+	}
+
+	for _, path := range []string{s.metaPath, s.dataPath} {
+		if err = s.fs.Rename(path+common.TempSuffix, path); err != nil {
+			return fmt.Errorf("%w: %w", ErrFileRenaming, err)
+		}
+		if err = s.fs.SyncDir(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("%w: %w", ErrFileSyncing, err)
+		}
+	}
+
+	return nil
+}
+
+// discard removes the files of a table that failed to be created. A failed
+// commit may have renamed some of them already, so both names are tried.
+func (s *SSTable) discard() error {
+	err := s.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{s.metaPath, s.dataPath} {
+		for _, name := range []string{path + common.TempSuffix, path} {
+			err = s.fs.Remove(name)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func createFile(fs vfs.FS, path string) (vfs.File, error) {
+	if err := fs.MkdirAll(filepath.Dir(path), 0770); err != nil {
 		return nil, err
 	}
-	return os.Create(path)
+	return fs.Create(path)
 }
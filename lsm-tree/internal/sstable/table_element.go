@@ -6,12 +6,35 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
+	"math"
+
+	"hw1/internal/vfs"
+)
+
+// A record is the key followed by a flags byte and the fields the flags
+// announce. Tables written before the expiry existed have flags 0 or 1.
+const (
+	flagTombstone byte = 1 << 0
+	flagExpires   byte = 1 << 1
+	flagData      byte = 1 << 2
+	flagOperand   byte = 1 << 3
 )
 
+// TableElement.Value is the key; the value stored with it is Data.
 type TableElement struct {
 	Value       string
+	Data        string
 	IsTombstone bool
+	// A merge operand keeps in Data an operand to be folded over the older
+	// versions of the key with a merge operator.
+	IsMergeOperand bool
+	// ExpiresAt is the Unix time in nanoseconds from which the element reads
+	// as deleted. Zero means it never expires.
+	ExpiresAt int64
+}
+
+func (e *TableElement) Expired(now int64) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt <= now
 }
 
 func (e *TableElement) toBytes() ([]byte, error) {
@@ -20,21 +43,61 @@ func (e *TableElement) toBytes() ([]byte, error) {
 	if _, err := buf.WriteString(e.Value); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
 	}
-	if err := binary.Write(buf, binary.LittleEndian, e.IsTombstone); err != nil {
+
+	var flags byte
+	if e.IsTombstone {
+		flags |= flagTombstone
+	}
+	if e.IsMergeOperand {
+		flags |= flagOperand
+	}
+	if e.ExpiresAt != 0 {
+		flags |= flagExpires
+	}
+	if e.Data != "" {
+		flags |= flagData
+	}
+	if err := buf.WriteByte(flags); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
 	}
 
+	if e.ExpiresAt != 0 {
+		if err := binary.Write(buf, binary.LittleEndian, e.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+		}
+	}
+	if e.Data != "" {
+		if err := binary.Write(buf, binary.LittleEndian, uint32(len(e.Data))); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+		}
+		if _, err := buf.WriteString(e.Data); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWritingBytes, err)
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
-func tableElementFromFileRandom(metaFile *os.File, dataFile *os.File, elementIdx int64) (*TableElement, error) {
-	elementMeta, err := setDataFileOffset(metaFile, dataFile, elementIdx, false)
+// EncodedSize returns the length of the record toBytes writes.
+func (e *TableElement) EncodedSize() int64 {
+	size := int64(len(e.Value)) + 1
+	if e.ExpiresAt != 0 {
+		size += 8
+	}
+	if e.Data != "" {
+		size += 4 + int64(len(e.Data))
+	}
+	return size
+}
+
+func tableElementFromFileRandom(metaFile vfs.File, dataFile vfs.File, dataSize int64, elementIdx int64) (*TableElement, error) {
+	elementMeta, err := metaFromFile(metaFile, elementIdx)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrSetFileOffset, err)
+		return nil, err
 	}
 
-	dataReader := bufio.NewReader(dataFile)
-	element, err := tableElementFromBytes(dataReader, int(elementMeta.length))
+	dataReader := io.NewSectionReader(dataFile, elementMeta.offset, math.MaxInt64-elementMeta.offset)
+	element, err := tableElementFromBytes(dataReader, int(elementMeta.length), dataSize-elementMeta.offset)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
 	}
@@ -42,13 +105,13 @@ func tableElementFromFileRandom(metaFile *os.File, dataFile *os.File, elementIdx
 	return element, nil
 }
 
-func tableElementFromFileConsecutive(metaReader *bufio.Reader, dataReader *bufio.Reader) (*TableElement, error) {
+func tableElementFromFileConsecutive(metaReader *bufio.Reader, dataReader *bufio.Reader, dataSize int64) (*TableElement, error) {
 	elementMeta, err := metaFromBytes(metaReader)
 	if err != nil {
 		return nil, err
 	}
 
-	element, err := tableElementFromBytes(dataReader, int(elementMeta.length))
+	element, err := tableElementFromBytes(dataReader, int(elementMeta.length), dataSize-elementMeta.offset)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +119,16 @@ func tableElementFromFileConsecutive(metaReader *bufio.Reader, dataReader *bufio
 	return element, nil
 }
 
-func tableElementFromBytes(reader io.Reader, valueLength int) (*TableElement, error) {
+// tableElementFromBytes reads a record with a key of valueLength bytes.
+// remaining is the number of bytes from the start of the record to the end of
+// the data file: the lengths of a corrupted table are checked against it
+// before anything is allocated.
+func tableElementFromBytes(reader io.Reader, valueLength int, remaining int64) (*TableElement, error) {
+	if valueLength < 0 || int64(valueLength) >= remaining {
+		return nil, fmt.Errorf("%w: key length %d overruns the data file", ErrCorruptedTable, valueLength)
+	}
+	remaining -= int64(valueLength) + 1
+
 	valueBytes := make([]byte, valueLength)
 	readBytes := 0
 
@@ -68,41 +140,84 @@ func tableElementFromBytes(reader io.Reader, valueLength int) (*TableElement, er
 		readBytes += n
 	}
 
-	tombstoneByte := make([]byte, 1)
-	_, err := reader.Read(tombstoneByte)
-	if err != nil {
+	flags := make([]byte, 1)
+	if _, err := io.ReadFull(reader, flags); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
 	}
 
-	return &TableElement{
-		Value:       string(valueBytes),
-		IsTombstone: tombstoneByte[0] == 1,
-	}, nil
+	element := &TableElement{
+		Value:          string(valueBytes),
+		IsTombstone:    flags[0]&flagTombstone != 0,
+		IsMergeOperand: flags[0]&flagOperand != 0,
+	}
+	if flags[0]&flagExpires != 0 {
+		if err := binary.Read(reader, binary.LittleEndian, &element.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+		}
+		remaining -= 8
+	}
+	if flags[0]&flagData != 0 {
+		var dataLength uint32
+		if err := binary.Read(reader, binary.LittleEndian, &dataLength); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+		}
+		if int64(dataLength) > remaining-4 {
+			return nil, fmt.Errorf("%w: value length %d overruns the data file", ErrCorruptedTable, dataLength)
+		}
+		data := make([]byte, dataLength)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+		}
+		element.Data = string(data)
+	}
+
+	return element, nil
 }
 
-func setDataFileOffset(metaFile *os.File, dataFile *os.File, elementIdx int64, setCorrectMetaOffset bool) (*meta, error) {
-	err := setMetaFileOffset(metaFile, elementIdx)
-	if err != nil {
-		return nil, err
+// readBatch reads the elements with indexes in [L, R] with a single read
+// per file.
+func readBatch(metaFile vfs.File, dataFile vfs.File, dataSize int64, L int, R int) ([]*TableElement, error) {
+	metaBytes := make([]byte, int64(R-L+1)*metaSize)
+	if _, err := metaFile.ReadAt(metaBytes, int64(L)*metaSize); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
 	}
 
-	metaReader := bufio.NewReader(metaFile)
-	elementMeta, err := metaFromBytes(metaReader)
-	if err != nil {
-		return nil, err
+	metas := make([]*meta, R-L+1)
+	metaReader := bytes.NewReader(metaBytes)
+	for i := range metas {
+		elementMeta, err := metaFromBytes(metaReader)
+		if err != nil {
+			return nil, err
+		}
+		metas[i] = elementMeta
 	}
 
-	_, err = dataFile.Seek(elementMeta.offset, 0)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrFileSeeking, err)
-	}
+	// Records don't store their size, so the data is read up to the end of
+	// the file in buffered chunks.
+	dataReader := io.NewSectionReader(dataFile, metas[0].offset, math.MaxInt64-metas[0].offset)
+	bufferedReader := bufio.NewReader(dataReader)
 
-	if setCorrectMetaOffset {
-		err = setMetaFileOffset(metaFile, elementIdx)
+	elements := make([]*TableElement, len(metas))
+	for i, elementMeta := range metas {
+		element, err := tableElementFromBytes(bufferedReader, int(elementMeta.length), dataSize-elementMeta.offset)
 		if err != nil {
 			return nil, err
 		}
+		elements[i] = element
 	}
 
-	return elementMeta, nil
+	return elements, nil
+}
+
+func consecutiveReaders(metaFile vfs.File, dataFile vfs.File, elementIdx int64) (*bufio.Reader, *bufio.Reader, error) {
+	elementMeta, err := metaFromFile(metaFile, elementIdx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrSetFileOffset, err)
+	}
+
+	metaOffset := elementIdx * metaSize
+	metaReader := bufio.NewReader(io.NewSectionReader(metaFile, metaOffset, math.MaxInt64-metaOffset))
+	dataReader := bufio.NewReader(io.NewSectionReader(dataFile, elementMeta.offset, math.MaxInt64-elementMeta.offset))
+
+	return metaReader, dataReader, nil
 }
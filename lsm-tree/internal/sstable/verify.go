@@ -0,0 +1,186 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"hw1/internal/comparator"
+	"hw1/internal/vfs"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Verify reads the whole table and checks the footer, the checksums, that
+// every record is well-formed and fills the data file exactly between its
+// index entry and the next one, that keys strictly increase and that the
+// footer counters match. The bloom filter isn't checked: it isn't stored but
+// rebuilt from the keys when the table is opened. Tables written before
+// checksums existed are verified without them.
+func Verify(fs vfs.FS, metaPath string, dataPath string, cmp comparator.Comparator) error {
+	table, err := Open(fs, metaPath, dataPath, cmp)
+	if errors.Is(err, ErrReadingFromFile) {
+		// The index points past the end of the data.
+		return fmt.Errorf("%w: %w", ErrCorruptedTable, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer table.Close()
+
+	return table.verify()
+}
+
+func (s *SSTable) verify() error {
+	props, err := readFooter(s.metaFile)
+	if err != nil {
+		return err
+	}
+	index, err := readAll(s.metaFile, int64(s.size)*metaSize)
+	if err != nil {
+		return err
+	}
+	dataSize, err := s.dataFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+	data, err := readAll(s.dataFile, dataSize)
+	if err != nil {
+		return err
+	}
+
+	if props.hasChecksums {
+		if crc32.Checksum(index, crc32cTable) != props.indexChecksum {
+			return fmt.Errorf("%w: index checksum mismatch", ErrCorruptedTable)
+		}
+		if crc32.Checksum(data, crc32cTable) != props.dataChecksum {
+			return fmt.Errorf("%w: data checksum mismatch", ErrCorruptedTable)
+		}
+	}
+
+	offset := int64(0)
+	tombstones, expiring := 0, 0
+	var previous string
+	for i := range s.size {
+		elementMeta, err := metaFromBytes(bytes.NewReader(index[int64(i)*metaSize:]))
+		if err != nil {
+			return err
+		}
+		if elementMeta.offset != offset {
+			return fmt.Errorf("%w: record %d starts at %d instead of %d", ErrCorruptedTable, i, elementMeta.offset, offset)
+		}
+		if elementMeta.length < 0 || elementMeta.length >= dataSize-offset {
+			return fmt.Errorf("%w: record %d overruns the data file", ErrCorruptedTable, i)
+		}
+
+		element, err := tableElementFromBytes(bytes.NewReader(data[offset:]), int(elementMeta.length), dataSize-offset)
+		if err != nil {
+			return fmt.Errorf("%w: record %d: %w", ErrCorruptedTable, i, err)
+		}
+		// Unknown flags or fields are lost by decoding.
+		encoded, err := element.toBytes()
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(data[offset:], encoded) {
+			return fmt.Errorf("%w: record %d is malformed", ErrCorruptedTable, i)
+		}
+		offset += int64(len(encoded))
+
+		if i > 0 && s.cmp.Compare(previous, element.Value) >= 0 {
+			return fmt.Errorf("%w: key %d is out of order", ErrCorruptedTable, i)
+		}
+		previous = element.Value
+		if element.IsTombstone {
+			tombstones++
+		}
+		if element.ExpiresAt != 0 {
+			expiring++
+		}
+	}
+
+	if offset != dataSize {
+		return fmt.Errorf("%w: %d bytes after the last record", ErrCorruptedTable, dataSize-offset)
+	}
+	if int64(tombstones) != props.tombstones || int64(expiring) != props.expiring {
+		return fmt.Errorf("%w: footer counts %d tombstones and %d expiring keys, found %d and %d",
+			ErrCorruptedTable, props.tombstones, props.expiring, tombstones, expiring)
+	}
+
+	return nil
+}
+
+// Salvage returns, in key order, every record of a possibly damaged table
+// that can still be decoded, and the number of index entries it skipped.
+// Without an intact footer every whole index entry is tried. A record is
+// kept only if it starts after the previous kept one and its key is greater.
+func Salvage(fs vfs.FS, metaPath string, dataPath string, cmp comparator.Comparator) ([]TableElement, int, error) {
+	metaFile, err := fs.Open(metaPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	defer metaFile.Close()
+	dataFile, err := fs.Open(dataPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrFileOpening, err)
+	}
+	defer dataFile.Close()
+
+	var entries int64
+	if props, err := readFooter(metaFile); err == nil {
+		if props.comparatorName != cmp.Name() {
+			return nil, 0, fmt.Errorf("%w: table uses %q, got %q", ErrComparatorMismatch, props.comparatorName, cmp.Name())
+		}
+		entries = props.size
+	} else {
+		metaFileSize, err := metaFile.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrFileSeeking, err)
+		}
+		entries = metaFileSize / metaSize
+	}
+	index, err := readAll(metaFile, entries*metaSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	dataSize, err := dataFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrFileSeeking, err)
+	}
+	data, err := readAll(dataFile, dataSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	elements := make([]TableElement, 0, entries)
+	skipped := 0
+	offset := int64(0)
+	for i := range entries {
+		elementMeta, err := metaFromBytes(bytes.NewReader(index[i*metaSize:]))
+		if err != nil || elementMeta.offset < offset || elementMeta.length < 0 || elementMeta.length >= dataSize-elementMeta.offset {
+			skipped++
+			continue
+		}
+
+		element, err := tableElementFromBytes(bytes.NewReader(data[elementMeta.offset:]), int(elementMeta.length), dataSize-elementMeta.offset)
+		if err != nil || (len(elements) > 0 && cmp.Compare(elements[len(elements)-1].Value, element.Value) >= 0) {
+			skipped++
+			continue
+		}
+
+		elements = append(elements, *element)
+		offset = elementMeta.offset + element.EncodedSize()
+	}
+
+	return elements, skipped, nil
+}
+
+func readAll(file vfs.File, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := file.ReadAt(buf, 0); err != nil && !(err == io.EOF && size == 0) {
+		return nil, fmt.Errorf("%w: %w", ErrReadingFromFile, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,135 @@
+package sstable
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+
+	"hw1/internal/bloom_filter"
+	"hw1/internal/common"
+	"hw1/internal/comparator"
+	"hw1/internal/vfs"
+)
+
+// tableWriter builds a table from elements added in the increasing order.
+type tableWriter struct {
+	table      *SSTable
+	metaWriter *bufio.Writer
+	dataWriter *bufio.Writer
+	offset     int
+}
+
+func newTableWriter(fs vfs.FS, metaFilepath string, dataFilepath string, cmp comparator.Comparator, sizeEstimation int) (*tableWriter, error) {
+	s := &SSTable{
+		fs:          fs,
+		metaPath:    metaFilepath,
+		dataPath:    dataFilepath,
+		bloomFilter: bloom_filter.New(max(sizeEstimation, 1)),
+		cmp:         cmp,
+	}
+
+	err := s.create()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableWriter{
+		table:      s,
+		metaWriter: bufio.NewWriter(s.metaFile),
+		dataWriter: bufio.NewWriter(s.dataFile),
+	}, nil
+}
+
+func (w *tableWriter) add(element *TableElement) error {
+	return w.table.writeElement(w.metaWriter, w.dataWriter, element, &w.offset)
+}
+
+func (w *tableWriter) fileSize() int64 {
+	return int64(w.offset) + int64(w.table.size)*metaSize
+}
+
+// close makes the table durable, or removes its files if it fails.
+func (w *tableWriter) close() (*SSTable, error) {
+	err := w.table.finish(w.metaWriter, w.dataWriter)
+	if err != nil {
+		_ = w.table.discard()
+		return nil, err
+	}
+
+	err = w.table.commit()
+	if err != nil {
+		_ = w.table.discard()
+		return nil, err
+	}
+
+	return w.table, nil
+}
+
+func (w *tableWriter) abort() {
+	_ = w.table.discard()
+}
+
+// SSTWriter builds a table offline from a stream of keys sorted by the
+// comparator, e.g. for ingesting it into a tree later.
+type SSTWriter struct {
+	w *tableWriter
+}
+
+func NewSSTWriter(fs vfs.FS, metaFilepath string, dataFilepath string, cmp comparator.Comparator) (*SSTWriter, error) {
+	w, err := newTableWriter(fs, metaFilepath, dataFilepath, cmp, common.FirstLevelSize)
+	if err != nil {
+		return nil, err
+	}
+	return &SSTWriter{w: w}, nil
+}
+
+func (w *SSTWriter) Add(key string) error {
+	return w.add(&TableElement{Value: key})
+}
+
+// AddWithExpiry adds a key that reads as deleted from expiresAt on.
+func (w *SSTWriter) AddWithExpiry(key string, expiresAt time.Time) error {
+	return w.add(&TableElement{Value: key, ExpiresAt: expiresAt.UnixNano()})
+}
+
+// AddElement adds an element with any combination of fields.
+func (w *SSTWriter) AddElement(element TableElement) error {
+	return w.add(&element)
+}
+
+// Delete writes a tombstone that hides the key in older data once the table
+// is ingested.
+func (w *SSTWriter) Delete(key string) error {
+	return w.add(&TableElement{Value: key, IsTombstone: true})
+}
+
+func (w *SSTWriter) add(element *TableElement) error {
+	table := w.w.table
+	if table.size > 0 && table.cmp.Compare(table.largest, element.Value) >= 0 {
+		return fmt.Errorf("%w: %q after %q", ErrKeysOutOfOrder, element.Value, table.largest)
+	}
+	if err := w.w.add(element); err != nil {
+		return fmt.Errorf("%w: %w", ErrWritingElement, err)
+	}
+	return nil
+}
+
+// FileSize returns the number of bytes written so far.
+func (w *SSTWriter) FileSize() int64 {
+	return w.w.fileSize()
+}
+
+// Finish makes the table durable and closes it. On failure the files are
+// removed.
+func (w *SSTWriter) Finish() error {
+	table, err := w.w.close()
+	if err != nil {
+		return err
+	}
+	return table.Close()
+}
+
+// Abort removes the files of an unfinished table.
+func (w *SSTWriter) Abort() {
+	w.w.abort()
+}
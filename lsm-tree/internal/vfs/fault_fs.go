@@ -0,0 +1,142 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+var ErrInjectedFault = errors.New("injected file system fault")
+
+// FaultFS wraps another file system and injects failures: once the write
+// limit is reached, every operation that modifies the file system fails,
+// like a disk that died or a machine that lost power.
+type FaultFS struct {
+	base FS
+
+	mu         sync.Mutex
+	writes     int
+	writeLimit int
+}
+
+func NewFaultFS(base FS) *FaultFS {
+	return &FaultFS{base: base, writeLimit: -1}
+}
+
+// SetWriteLimit allows n more modifying operations. A negative n removes
+// the limit.
+func (f *FaultFS) SetWriteLimit(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if n < 0 {
+		f.writeLimit = -1
+	} else {
+		f.writeLimit = f.writes + n
+	}
+}
+
+// Writes returns the number of modifying operations performed so far.
+func (f *FaultFS) Writes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writes
+}
+
+// Crash drops everything that wasn't synced if the wrapped file system can
+// simulate it, and removes the write limit, as the machine is restarted.
+func (f *FaultFS) Crash() {
+	if crasher, ok := f.base.(interface{ Crash() }); ok {
+		crasher.Crash()
+	}
+	f.SetWriteLimit(-1)
+}
+
+func (f *FaultFS) write() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.writeLimit >= 0 && f.writes >= f.writeLimit {
+		return ErrInjectedFault
+	}
+	f.writes++
+	return nil
+}
+
+func (f *FaultFS) Create(name string) (File, error) {
+	if err := f.write(); err != nil {
+		return nil, err
+	}
+	file, err := f.base.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f}, nil
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	file, err := f.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f}, nil
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if err := f.write(); err != nil {
+		return err
+	}
+	return f.base.Remove(name)
+}
+
+func (f *FaultFS) Rename(oldname string, newname string) error {
+	if err := f.write(); err != nil {
+		return err
+	}
+	return f.base.Rename(oldname, newname)
+}
+
+func (f *FaultFS) Link(oldname string, newname string) error {
+	if err := f.write(); err != nil {
+		return err
+	}
+	return f.base.Link(oldname, newname)
+}
+
+func (f *FaultFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.write(); err != nil {
+		return err
+	}
+	return f.base.MkdirAll(path, perm)
+}
+
+func (f *FaultFS) ReadDir(path string) ([]string, error) {
+	return f.base.ReadDir(path)
+}
+
+func (f *FaultFS) SyncDir(path string) error {
+	if err := f.write(); err != nil {
+		return err
+	}
+	return f.base.SyncDir(path)
+}
+
+type faultFile struct {
+	File
+	fs *FaultFS
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.fs.write(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.write(); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
@@ -0,0 +1,162 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"hw1/internal/common"
+)
+
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Sync() error
+	Name() string
+}
+
+// FS is the subset of the file system the tree works with. A file's content
+// survives a crash only after Sync; creations, renames and removals do only
+// after SyncDir of the parent directory.
+type FS interface {
+	Create(name string) (File, error)
+	Open(name string) (File, error)
+	Remove(name string) error
+	Rename(oldname string, newname string) error
+	Link(oldname string, newname string) error
+	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(path string) ([]string, error)
+	SyncDir(path string) error
+}
+
+type osFS struct{}
+
+var Default FS = osFS{}
+
+func (osFS) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldname string, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) Link(oldname string, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) ReadDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (osFS) SyncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// LinkOrCopy hard-links oldname to newname, or copies and syncs the file if
+// the file system can't link it, e.g. across devices. Any other failure of
+// the link is returned. The parent directory of newname isn't synced.
+func LinkOrCopy(fs FS, oldname string, newname string) error {
+	err := fs.Link(oldname, newname)
+	if err == nil || !linkUnsupported(err) {
+		return err
+	}
+	return CopyFile(fs, oldname, newname)
+}
+
+// linkUnsupported reports whether the link failed because the files are on
+// different devices or the file system has no hard links, rather than
+// because of the files themselves.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
+
+// CopyFile copies oldname to a new file newname and syncs it. The parent
+// directory of newname isn't synced.
+func CopyFile(fs FS, oldname string, newname string) error {
+	src, err := fs.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := fs.Create(newname)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// WriteFileAtomic replaces the file at path with data, so that after a crash
+// it holds either the old or the new content.
+func WriteFileAtomic(fs FS, path string, data []byte) error {
+	tempPath := path + common.TempSuffix
+	file, err := fs.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = fs.Rename(tempPath, path); err != nil {
+		return err
+	}
+	return fs.SyncDir(filepath.Dir(path))
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/resp"
+)
+
+// The commands follow their Redis namesakes where the tree allows:
+//
+//	PING [MESSAGE]
+//	GET KEY                     the value, or null if the key is absent
+//	SET KEY VALUE
+//	DEL KEY [KEY ...]           the number of keys given, as deleting
+//	                            doesn't look up whether they existed
+//	SCAN MIN MAX [LIMIT COUNT] [REV]
+//	                            keys and values alternating, at most COUNT
+//	                            pairs, 1000 by default; MIN and MAX are
+//	                            "[key" (inclusive), "(key" (exclusive), "-"
+//	                            and "+" (unbounded), as in ZRANGEBYLEX
+//	MULTI, EXEC, DISCARD        SET and DEL between MULTI and EXEC are
+//	                            applied as a single lsm_tree.Batch
+//	QUIT
+type conn struct {
+	server *Server
+	r      *resp.Reader
+	w      *resp.Writer
+	quit   bool
+
+	// inMulti is set between MULTI and EXEC. A queued command with invalid
+	// arguments aborts the transaction.
+	inMulti bool
+	aborted bool
+	queued  [][]string
+}
+
+// A SCAN returns a page of at most maxScanLimit keys; the next page starts
+// after the last key.
+const (
+	defaultScanLimit = 1000
+	maxScanLimit     = 10000
+)
+
+type handler struct {
+	run func(c *conn, args []string)
+	// minArgs and maxArgs bound the number of arguments, maxArgs < 0 means
+	// no bound.
+	minArgs, maxArgs int
+	// Only writes can be queued by MULTI.
+	write bool
+}
+
+var handlers = map[string]handler{
+	"PING":    {runPing, 0, 1, false},
+	"GET":     {runGet, 1, 1, false},
+	"SET":     {runSet, 2, 2, true},
+	"DEL":     {runDel, 1, -1, true},
+	"SCAN":    {runScan, 2, 5, false},
+	"MULTI":   {runMulti, 0, 0, false},
+	"EXEC":    {runExec, 0, 0, false},
+	"DISCARD": {runDiscard, 0, 0, false},
+	"QUIT":    {runQuit, 0, 0, false},
+	// redis-cli asks for the command docs on start.
+	"COMMAND": {runCommand, 0, -1, false},
+}
+
+func (c *conn) execute(args []string) {
+	name := strings.ToUpper(args[0])
+	h, ok := handlers[name]
+	if !ok {
+		c.abort()
+		c.w.WriteError("ERR unknown command '" + args[0] + "'")
+		return
+	}
+	if n := len(args) - 1; n < h.minArgs || h.maxArgs >= 0 && n > h.maxArgs {
+		c.abort()
+		c.w.WriteError("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+
+	if c.inMulti && !h.write && name != "EXEC" && name != "DISCARD" && name != "MULTI" && name != "QUIT" {
+		c.abort()
+		c.w.WriteError("ERR only SET and DEL can be used in MULTI")
+		return
+	}
+	if c.inMulti && h.write {
+		c.queued = append(c.queued, args)
+		c.w.WriteSimple("QUEUED")
+		return
+	}
+	h.run(c, args[1:])
+}
+
+// abort fails the transaction in progress, if any.
+func (c *conn) abort() {
+	if c.inMulti {
+		c.aborted = true
+	}
+}
+
+func (c *conn) writeError(err error) {
+	c.server.logger.Warn("command failed", "error", err)
+	c.w.WriteError("ERR " + err.Error())
+}
+
+func runPing(c *conn, args []string) {
+	if len(args) > 0 {
+		c.w.WriteBulk(args[0])
+		return
+	}
+	c.w.WriteSimple("PONG")
+}
+
+func runGet(c *conn, args []string) {
+	value, ok, err := c.server.tree.GetContext(c.server.ctx, args[0])
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+	if !ok {
+		c.w.WriteNull()
+		return
+	}
+	c.w.WriteBulk(value)
+}
+
+func runSet(c *conn, args []string) {
+	if err := c.server.tree.PutContext(c.server.ctx, args[0], args[1]); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.w.WriteSimple("OK")
+}
+
+func runDel(c *conn, args []string) {
+	b := &lsm_tree.Batch{}
+	for _, key := range args {
+		b.Delete(key)
+	}
+	if err := c.server.tree.ApplyContext(c.server.ctx, b); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.w.WriteInteger(int64(len(args)))
+}
+
+func runScan(c *conn, args []string) {
+	opts := lsm_tree.SearchRangeOptions{Limit: defaultScanLimit}
+	keyL, leftBound, okL := parseBound(args[0], "-")
+	keyR, rightBound, okR := parseBound(args[1], "+")
+	if !okL || !okR {
+		c.w.WriteError("ERR min or max not valid string range item")
+		return
+	}
+	opts.LeftBound, opts.RightBound = leftBound, rightBound
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LIMIT":
+			if i+1 == len(args) {
+				c.w.WriteError("ERR syntax error")
+				return
+			}
+			limit, err := strconv.Atoi(args[i+1])
+			if err != nil || limit <= 0 || limit > maxScanLimit {
+				c.w.WriteError("ERR LIMIT must be between 1 and " + strconv.Itoa(maxScanLimit))
+				return
+			}
+			opts.Limit = limit
+			i++
+		case "REV":
+			opts.Reverse = true
+		default:
+			c.w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	res, err := c.server.tree.SearchRangeContext(c.server.ctx, keyL, keyR, opts)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.WriteArrayHeader(2 * len(res.Keys))
+	for i := range res.Keys {
+		c.w.WriteBulk(res.Keys[i])
+		c.w.WriteBulk(res.Values[i])
+	}
+}
+
+// parseBound parses a range end in the ZRANGEBYLEX syntax, where unbounded
+// is "-" for the left end and "+" for the right one.
+func parseBound(arg string, unbounded string) (string, lsm_tree.Bound, bool) {
+	switch {
+	case arg == unbounded:
+		return "", lsm_tree.Unbounded, true
+	case strings.HasPrefix(arg, "["):
+		return arg[1:], lsm_tree.Inclusive, true
+	case strings.HasPrefix(arg, "("):
+		return arg[1:], lsm_tree.Exclusive, true
+	}
+	return "", 0, false
+}
+
+func runMulti(c *conn, _ []string) {
+	if c.inMulti {
+		c.w.WriteError("ERR MULTI calls can not be nested")
+		return
+	}
+	c.inMulti = true
+	c.w.WriteSimple("OK")
+}
+
+func runExec(c *conn, _ []string) {
+	if !c.inMulti {
+		c.w.WriteError("ERR EXEC without MULTI")
+		return
+	}
+	queued, aborted := c.queued, c.aborted
+	c.resetMulti()
+	if aborted {
+		c.w.WriteError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+
+	b := &lsm_tree.Batch{}
+	for _, args := range queued {
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			b.Put(args[1], args[2])
+		case "DEL":
+			for _, key := range args[1:] {
+				b.Delete(key)
+			}
+		}
+	}
+	if err := c.server.tree.ApplyContext(c.server.ctx, b); err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.WriteArrayHeader(len(queued))
+	for _, args := range queued {
+		if strings.ToUpper(args[0]) == "SET" {
+			c.w.WriteSimple("OK")
+		} else {
+			c.w.WriteInteger(int64(len(args) - 1))
+		}
+	}
+}
+
+func runDiscard(c *conn, _ []string) {
+	if !c.inMulti {
+		c.w.WriteError("ERR DISCARD without MULTI")
+		return
+	}
+	c.resetMulti()
+	c.w.WriteSimple("OK")
+}
+
+func (c *conn) resetMulti() {
+	c.inMulti, c.aborted, c.queued = false, false, nil
+}
+
+func runQuit(c *conn, _ []string) {
+	c.quit = true
+	c.w.WriteSimple("OK")
+}
+
+func runCommand(c *conn, _ []string) {
+	c.w.WriteArrayHeader(0)
+}
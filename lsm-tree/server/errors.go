@@ -0,0 +1,5 @@
+package server
+
+import "errors"
+
+var ErrServerClosed = errors.New("server closed")
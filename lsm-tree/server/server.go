@@ -0,0 +1,241 @@
+// Package server serves a tree over the Redis protocol, so that several
+// processes can share it. See commands.go for the supported commands.
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/resp"
+)
+
+type Options struct {
+	// Logger receives records about connections and failed commands.
+	// Nothing is logged by default.
+	Logger *slog.Logger
+	// Connections idle for IdleTimeout are closed. Zero means never.
+	IdleTimeout time.Duration
+}
+
+// Server runs the commands of every connection in order, replying once the
+// pipelined commands already received are done. It doesn't own the tree,
+// which must stay open until the server is shut down.
+type Server struct {
+	tree        *lsm_tree.LSMTree
+	logger      *slog.Logger
+	idleTimeout time.Duration
+
+	// ctx is canceled by Close to abort commands waiting for the tree.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	shuttingDown bool
+	listeners    map[net.Listener]struct{}
+	conns        map[net.Conn]struct{}
+	handlers     sync.WaitGroup
+}
+
+func New(tree *lsm_tree.LSMTree, opts Options) *Server {
+	if opts.Logger == nil {
+		opts.Logger = slog.New(common.DiscardHandler{})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		tree:        tree,
+		logger:      opts.Logger,
+		idleTimeout: opts.IdleTimeout,
+		ctx:         ctx,
+		cancel:      cancel,
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until the server is shut down, when it
+// returns ErrServerClosed. l is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		_ = l.Close()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.closing() {
+				return ErrServerClosed
+			}
+			// Retry timeouts with a growing delay.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				s.logger.Warn("accepting connection failed", "error", err, "retry_in", delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		if !s.track(conn) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go s.handle(conn)
+	}
+}
+
+// Shutdown stops accepting connections and waits for every connection to
+// finish the commands it has received. If ctx is done first, the remaining
+// connections are closed and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	// Connections waiting for the next command give up at once, the others
+	// once they are done with what they have read.
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		_ = s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and every connection at once, aborting the
+// commands that wait for the tree.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shuttingDown = true
+	s.cancel()
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	return nil
+}
+
+func (s *Server) closing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// track registers a new connection unless the server is shutting down.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.handlers.Done()
+}
+
+func (s *Server) handle(netConn net.Conn) {
+	defer s.untrack(netConn)
+	defer netConn.Close()
+
+	logger := s.logger.With("remote", netConn.RemoteAddr().String())
+	logger.Debug("accepted connection")
+
+	c := &conn{
+		server: s,
+		r:      resp.NewReader(netConn),
+		w:      resp.NewWriter(netConn),
+	}
+	for {
+		// Replies are sent once the pipelined commands are done, and the
+		// connection ends between commands when shutting down.
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				logger.Debug("writing replies failed", "error", err)
+				return
+			}
+			// The deadline is moved before checking for a shutdown, so the
+			// one set by Shutdown isn't overwritten.
+			if s.idleTimeout > 0 {
+				_ = netConn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+			}
+			if s.closing() {
+				return
+			}
+		}
+
+		args, err := c.r.ReadCommand()
+		if errors.Is(err, resp.ErrProtocol) {
+			c.w.WriteError("ERR " + err.Error())
+			_ = c.w.Flush()
+			logger.Warn("closing connection", "error", err)
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !s.closing() {
+				logger.Debug("reading command failed", "error", err)
+			}
+			return
+		}
+
+		c.execute(args)
+		if c.quit {
+			_ = c.w.Flush()
+			return
+		}
+	}
+}
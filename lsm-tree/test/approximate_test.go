@@ -0,0 +1,70 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+)
+
+// Every key is written with a 7-byte value: its record takes the key, the
+// flags byte, the value length and the value, and 16 bytes of index.
+const (
+	approximateRecordSize = 8 + 1 + 4 + 7
+	approximateIndexSize  = 16
+)
+
+func checkApproximation(t *testing.T, tree *lsm_tree.LSMTree, start string, end string, count int, size int64) {
+	t.Helper()
+
+	gotCount, err := tree.ApproximateCount(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotSize, err := tree.ApproximateSize(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotCount != count || gotSize != size {
+		t.Fatalf("[%s, %s] holds %d keys in %d bytes, expected %d keys in %d bytes", start, end, gotCount, gotSize, count, size)
+	}
+}
+
+func TestApproximateSizeAndCount(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	for i := range 1000 {
+		if err := tree.Put(fmt.Sprintf("key/%04d", i), fmt.Sprintf("v%06d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkApproximation(t, tree, "key/0100", "key/0199", 100, 100*(approximateRecordSize+approximateIndexSize))
+	checkApproximation(t, tree, "key/0999", "key/9999", 1, approximateRecordSize+approximateIndexSize)
+	checkApproximation(t, tree, "a", "b", 0, 0)
+	checkApproximation(t, tree, "key/0100a", "key/0100z", 0, 0)
+
+	// The RAM component has no index yet; overwritten and deleted keys are
+	// counted once per version. A tombstone takes the key and the flags byte.
+	for i := range 10 {
+		if err := tree.Put(fmt.Sprintf("key/%04d", 100+i), "updated"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Delete("key/0150"); err != nil {
+		t.Fatal(err)
+	}
+	checkApproximation(t, tree, "key/0100", "key/0199", 111,
+		100*(approximateRecordSize+approximateIndexSize)+10*approximateRecordSize+8+1)
+
+	if _, err := tree.ApproximateSize("b", "a"); !errors.Is(err, lsm_tree.ErrInvalidRange) {
+		t.Fatalf("ApproximateSize(b, a) = %v, expected %v", err, lsm_tree.ErrInvalidRange)
+	}
+	if _, err := tree.ApproximateCount("b", "a"); !errors.Is(err, lsm_tree.ErrInvalidRange) {
+		t.Fatalf("ApproximateCount(b, a) = %v, expected %v", err, lsm_tree.ErrInvalidRange)
+	}
+}
@@ -0,0 +1,256 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"hw1/backup"
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+func TestBackups(t *testing.T) {
+	fs := vfs.NewMemFS()
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	engine, err := backup.Open(fs, "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second round is too small to trigger a merge, so the tables of the
+	// first backup are still live and aren't copied again.
+	snapshots := make(map[int][]string)
+	var infos []*backup.BackupInfo
+	for round, keys := range []int{2 * common.FirstLevelSize, 1000} {
+		for i := range keys {
+			if err = tree.Add(fmt.Sprintf("%d-%06d", round, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = tree.Delete("0-000000"); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := engine.CreateBackup(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots[info.ID] = allKeys(t, tree)
+		infos = append(infos, info)
+	}
+
+	shared := 0
+	for _, file := range infos[1].Files {
+		if slices.ContainsFunc(infos[0].Files, func(f backup.BackupFile) bool { return f.Hash == file.Hash }) {
+			shared++
+		}
+	}
+	if shared < 4 {
+		t.Fatalf("backups share %d files", shared)
+	}
+
+	backups, err := engine.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].ID != infos[0].ID || backups[1].ID != infos[1].ID {
+		t.Fatalf("unexpected backups %+v", backups)
+	}
+
+	for _, info := range infos {
+		if err = engine.VerifyBackup(info.ID); err != nil {
+			t.Fatal(err)
+		}
+		checkRestoredBackup(t, fs, engine, info.ID, snapshots[info.ID])
+	}
+
+	if err = engine.PurgeOldBackups(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.VerifyBackup(infos[0].ID); !errors.Is(err, backup.ErrBackupNotFound) {
+		t.Fatalf("verifying a purged backup returned %v", err)
+	}
+	if err = engine.VerifyBackup(infos[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := fs.Create(filepath.Join("backups", "shared", infos[1].Files[len(infos[1].Files)-1].Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.Write([]byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	if err = engine.VerifyBackup(infos[1].ID); !errors.Is(err, backup.ErrCorruptedFile) {
+		t.Fatalf("verifying a corrupted backup returned %v", err)
+	}
+}
+
+func checkRestoredBackup(t *testing.T, fs vfs.FS, engine *backup.Engine, id int, expected []string) {
+	t.Helper()
+
+	dir := fmt.Sprintf("restored-%d", id)
+	if err := engine.RestoreBackup(id, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RestoreBackup(id, dir); !errors.Is(err, backup.ErrTargetNotEmpty) {
+		t.Fatalf("restoring into a non-empty directory returned %v", err)
+	}
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	if keys := allKeys(t, tree); !slices.Equal(keys, expected) {
+		t.Fatalf("backup %d restored %d keys, expected %d", id, len(keys), len(expected))
+	}
+}
+
+// linkRecordingFS records the files linked through it.
+type linkRecordingFS struct {
+	vfs.FS
+	links []string
+}
+
+func (r *linkRecordingFS) Link(oldname string, newname string) error {
+	r.links = append(r.links, newname)
+	return r.FS.Link(oldname, newname)
+}
+
+func TestBackupFilesAreCopied(t *testing.T) {
+	fs := &linkRecordingFS{FS: vfs.NewMemFS()}
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	engine, err := backup.Open(fs, "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []*backup.BackupInfo
+	for round := range 2 {
+		for i := range 1000 {
+			if err = tree.Add(fmt.Sprintf("%d-%06d", round, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		info, err := engine.CreateBackup(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+
+	// Only the checkpoint links the files of the tree; the shared files are
+	// copies.
+	for _, link := range fs.links {
+		if !strings.HasPrefix(link, filepath.Join("backups", "staging")) {
+			t.Fatalf("%s is a link", link)
+		}
+	}
+
+	// The tables of the first backup are shared with the second one.
+	for _, file := range infos[0].Files {
+		if file.Path != common.ManifestFile && !slices.Contains(infos[1].Files, file) {
+			t.Fatalf("%+v is missing from the second backup", file)
+		}
+	}
+
+	fs.links = nil
+	checkRestoredBackup(t, fs, engine, infos[1].ID, allKeys(t, tree))
+	if len(fs.links) > 0 {
+		t.Fatalf("restoring linked %v", fs.links)
+	}
+}
+
+func TestBackupsOfSeveralTrees(t *testing.T) {
+	fs := vfs.NewMemFS()
+	engine, err := backup.Open(fs, "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both trees have a table 0 of the same size with different keys.
+	ids := make(map[string]int)
+	for _, name := range []string{"a", "b"} {
+		tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tree.Put("k-"+name, "v-"+name); err != nil {
+			t.Fatal(err)
+		}
+		if err = tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := engine.CreateBackup(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = info.ID
+		if err = tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, id := range ids {
+		if err = engine.VerifyBackup(id); err != nil {
+			t.Fatal(err)
+		}
+		checkRestoredBackup(t, fs, engine, id, []string{"k-" + name})
+	}
+}
+
+func TestBackupIDsAreNotReused(t *testing.T) {
+	fs := vfs.NewMemFS()
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	engine, err := backup.Open(fs, "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]int, 0, 3)
+	for range 3 {
+		if err = tree.Add(fmt.Sprintf("key-%d", len(ids))); err != nil {
+			t.Fatal(err)
+		}
+		info, err := engine.CreateBackup(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, info.ID)
+
+		if err = engine.PurgeOldBackups(0); err != nil {
+			t.Fatal(err)
+		}
+		// A reopened engine continues the sequence as well.
+		if engine, err = backup.Open(fs, "backups"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !slices.Equal(ids, []int{1, 2, 3}) {
+		t.Fatalf("backup IDs %v, expected [1 2 3]", ids)
+	}
+}
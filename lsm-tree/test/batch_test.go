@@ -0,0 +1,42 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+)
+
+func TestBatch(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	for i := range common.FirstLevelSize - 10 {
+		if err := tree.Add(fmt.Sprintf("key/%06d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The batch fills the RAM component halfway through, but it's flushed
+	// into the same table as the rest of the batch.
+	b := &lsm_tree.Batch{}
+	for i := range 20 {
+		b.Put(fmt.Sprintf("batch/%02d", i), fmt.Sprint(i))
+	}
+	b.Delete("key/000000")
+	b.Put("batch/00", "overridden")
+	if err := tree.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+
+	levels, err := tree.Levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels[0]) != 1 || levels[0][0][0].Elements != common.FirstLevelSize+10 {
+		t.Fatalf("unexpected layout %+v", levels)
+	}
+	checkValue(t, tree, "batch/00", "overridden", true)
+	checkValue(t, tree, "batch/19", "19", true)
+	checkValue(t, tree, "key/000000", "", false)
+}
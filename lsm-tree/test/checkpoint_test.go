@@ -0,0 +1,201 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"hw1/backup"
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := lsm_tree.Open(lsm_tree.Options{Dir: filepath.Join(dir, "db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// Some keys are still in the RAM component when the checkpoint is made.
+	for i := range common.FirstLevelSize * 3 / 2 {
+		if err = tree.Add(fmt.Sprintf("key-%06d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := allKeys(t, tree)
+
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	if err = tree.Checkpoint(checkpointDir); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Checkpoint(checkpointDir); err == nil {
+		t.Fatal("checkpoint into an existing directory succeeded")
+	}
+
+	// The tree keeps working and merges away the tables the checkpoint links.
+	for i := range common.FirstLevelSize * common.MaxLevelSize {
+		if err = tree.Delete(fmt.Sprintf("key-%06d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := lsm_tree.Open(lsm_tree.Options{Dir: checkpointDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+
+	if keys := allKeys(t, checkpoint); !slices.Equal(keys, expected) {
+		t.Fatalf("checkpoint has %d keys, expected %d", len(keys), len(expected))
+	}
+	if keys := allKeys(t, tree); len(keys) != 0 {
+		t.Fatalf("tree has %d keys after deleting all of them", len(keys))
+	}
+}
+
+// blockingLinkFS blocks the first link until release is closed.
+type blockingLinkFS struct {
+	*vfs.MemFS
+	once    sync.Once
+	linking chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingLinkFS) Link(oldname string, newname string) error {
+	b.once.Do(func() {
+		close(b.linking)
+		<-b.release
+	})
+	return b.MemFS.Link(oldname, newname)
+}
+
+func TestCheckpointDoesNotBlockTheTree(t *testing.T) {
+	fs := &blockingLinkFS{MemFS: vfs.NewMemFS(), linking: make(chan struct{}), release: make(chan struct{})}
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := range 100 {
+		if err = tree.Add(fmt.Sprintf("key-%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error)
+	go func() { done <- tree.Checkpoint("checkpoint") }()
+	<-fs.linking
+
+	// While the files are being linked, the tree serves reads and writes and
+	// even merges away the tables the checkpoint is linking.
+	checkValue(t, tree, "key-000", "", true)
+	for i := range 100 {
+		if err = tree.Delete(fmt.Sprintf("key-%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if count := countKeys(t, tree); count != 0 {
+		t.Fatalf("tree has %d keys after deleting all of them", count)
+	}
+
+	close(fs.release)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "checkpoint"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+	if count := countKeys(t, checkpoint); count != 100 {
+		t.Fatalf("checkpoint has %d keys, expected 100", count)
+	}
+
+	// The merged away tables are removed once the checkpoint is done.
+	names, err := fs.ReadDir(common.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("data files %v are left after the checkpoint", names)
+	}
+}
+
+func TestCheckpointOfReadOnlyTree(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err = tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+		if err = tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed merge switches the tree to the read-only mode, but its tables
+	// can still be checkpointed and backed up.
+	fs.SetWriteLimit(0)
+	if err = tree.CompactAll(context.Background(), nil); err == nil {
+		t.Fatal("compaction succeeded without writes")
+	}
+	if tree.Err() == nil {
+		t.Fatal("failed compaction left the tree writable")
+	}
+	fs.SetWriteLimit(-1)
+
+	checkCheckpoint := func(tree *lsm_tree.LSMTree, dir string) {
+		t.Helper()
+
+		if err := tree.Checkpoint(dir); err != nil {
+			t.Fatal(err)
+		}
+		checkpoint, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer checkpoint.Close()
+		if keys := allKeys(t, checkpoint); !slices.Equal(keys, []string{"a", "b", "c"}) {
+			t.Fatalf("checkpoint has keys %v, expected [a b c]", keys)
+		}
+	}
+	checkCheckpoint(tree, "after-error")
+
+	engine, err := backup.Open(fs, "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = engine.CreateBackup(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "db", ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	checkCheckpoint(readOnly, "read-only")
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+)
+
+// tenantFilter drops the keys of deleted tenants and upgrades legacy values.
+type tenantFilter struct {
+	mu     sync.Mutex
+	levels map[int]struct{}
+}
+
+func (f *tenantFilter) Filter(level int, key string, value string) (lsm_tree.FilterDecision, string) {
+	f.mu.Lock()
+	f.levels[level] = struct{}{}
+	f.mu.Unlock()
+
+	if strings.HasPrefix(key, "deleted/") {
+		return lsm_tree.FilterRemove, ""
+	}
+	if legacy, ok := strings.CutPrefix(value, "legacy:"); ok {
+		return lsm_tree.FilterChangeValue, legacy
+	}
+	return lsm_tree.FilterKeep, ""
+}
+
+func checkValue(t *testing.T, tree *lsm_tree.LSMTree, key string, value string, present bool) {
+	t.Helper()
+
+	got, ok, err := tree.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != present || got != value {
+		t.Fatalf("Get(%s) = %q, %v, expected %q, %v", key, got, ok, value, present)
+	}
+}
+
+func TestCompactionFilter(t *testing.T) {
+	filter := &tenantFilter{levels: make(map[int]struct{})}
+	tree := openMemTree(t, lsm_tree.Options{CompactionFilter: filter})
+
+	// Every round writes a version of every key, the last round fills the
+	// first level and triggers a merge into the second one.
+	for round := range common.MaxLevelSize {
+		for i := range 100 {
+			for _, tenant := range []string{"active", "deleted"} {
+				key := fmt.Sprintf("%s/%03d", tenant, i)
+				if err := tree.Put(key, fmt.Sprintf("legacy:%d", round)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := filter.levels[1]; !ok || len(filter.levels) != 1 {
+		t.Fatalf("filter was called for levels %v", filter.levels)
+	}
+	last := fmt.Sprint(common.MaxLevelSize - 1)
+	checkValue(t, tree, "active/000", last, true)
+	checkValue(t, tree, "deleted/000", "", false)
+
+	// Removed keys stay deleted when newer data is compacted with them, and
+	// values written after the merge are filtered by the next one.
+	if err := tree.Put("active/001", "legacy:new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put("deleted/002", "live"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, tree, "active/001", "new", true)
+	checkValue(t, tree, "active/002", last, true)
+	checkValue(t, tree, "deleted/001", "", false)
+	checkValue(t, tree, "deleted/002", "", false)
+
+	// Range searches return the values along with the keys.
+	res, err := tree.SearchRangeWithOptions("active/000", "active/002", lsm_tree.SearchRangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Values, []string{last, "new", last}) {
+		t.Fatalf("SearchRange values = %q", res.Values)
+	}
+
+	if count := countKeys(t, tree); count != 100 {
+		t.Fatalf("%d keys left after compaction", count)
+	}
+}
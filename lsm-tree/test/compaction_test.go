@@ -0,0 +1,249 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/comparator"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+const compactionTestTableSize = 10000
+
+// fillCompactionTestTree flushes three tables: a/*, b/* and one with c/* and
+// tombstones for the first 100 keys of b/*, so the last two overlap.
+func fillCompactionTestTree(t *testing.T, tree *lsm_tree.LSMTree) {
+	t.Helper()
+
+	for _, prefix := range []string{"a", "b", "c"} {
+		if prefix == "c" {
+			for i := range 100 {
+				if err := tree.Delete(fmt.Sprintf("b/%05d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for i := range compactionTestTableSize {
+			if err := tree.Add(fmt.Sprintf("%s/%05d", prefix, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func treeLevels(t *testing.T, tree *lsm_tree.LSMTree) [][][]lsm_tree.TableInfo {
+	t.Helper()
+
+	levels, err := tree.Levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return levels
+}
+
+func TestCompactRange(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+	fillCompactionTestTree(t, tree)
+	initial := treeLevels(t, tree)
+	if len(initial) != 1 || len(initial[0]) != 3 {
+		t.Fatalf("unexpected layout %+v", initial)
+	}
+	keys := 3*compactionTestTableSize - 100
+
+	ctx := context.Background()
+	if err := tree.CompactRange(ctx, "c", "b", nil); !errors.Is(err, lsm_tree.ErrInvalidRange) {
+		t.Fatalf("CompactRange(c, b) = %v, expected %v", err, lsm_tree.ErrInvalidRange)
+	}
+	if err := tree.CompactRange(ctx, "x", "z", nil); err != nil {
+		t.Fatal(err)
+	}
+	if levels := treeLevels(t, tree); !reflect.DeepEqual(levels, initial) {
+		t.Fatalf("compacting an empty range changed the layout to %+v", levels)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := tree.CompactRange(cancelled, "a", "z", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CompactRange with a cancelled context = %v", err)
+	}
+
+	// Cancelling in the middle of the merge discards its output and keeps
+	// the tree writable.
+	cancelled, cancel = context.WithCancel(ctx)
+	defer cancel()
+	err := tree.CompactRange(cancelled, "c/00050", "c/00060", func(lsm_tree.CompactionProgress) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CompactRange cancelled by the progress callback = %v", err)
+	}
+	if levels := treeLevels(t, tree); !reflect.DeepEqual(levels, initial) {
+		t.Fatalf("cancelled compaction changed the layout to %+v", levels)
+	}
+	if count := countKeys(t, tree); count != keys {
+		t.Fatalf("%d keys after a cancelled compaction, expected %d", count, keys)
+	}
+	if err = tree.Add("d/00000"); err != nil {
+		t.Fatal(err)
+	}
+	keys++
+
+	// The c/* table overlaps b/* because of the tombstones, so both are
+	// merged, while a/* is left alone.
+	var progress []lsm_tree.CompactionProgress
+	err = tree.CompactRange(ctx, "c/00050", "c/00060", func(p lsm_tree.CompactionProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels := treeLevels(t, tree)
+	if len(levels) != 1 || len(levels[0]) != 2 || !reflect.DeepEqual(levels[0][0], initial[0][0]) {
+		t.Fatalf("unexpected layout %+v", levels)
+	}
+	if merged := levels[0][1]; len(merged) != 1 || merged[0].Elements != 2*compactionTestTableSize-100 {
+		t.Fatalf("unexpected merged run %+v", merged)
+	}
+
+	inputElements := 2*compactionTestTableSize + 100
+	if len(progress) < 2 {
+		t.Fatalf("progress was reported %d times", len(progress))
+	}
+	for i, p := range progress {
+		if p.InputTables != 2 || p.ElementsTotal != inputElements || i > 0 && p.ElementsMerged < progress[i-1].ElementsMerged {
+			t.Fatalf("unexpected progress %+v", progress)
+		}
+	}
+	if last := progress[len(progress)-1]; last.ElementsMerged != inputElements {
+		t.Fatalf("last progress %+v, expected every element merged", last)
+	}
+
+	if count := countKeys(t, tree); count != keys {
+		t.Fatalf("%d keys after compaction, expected %d", count, keys)
+	}
+	checkValue(t, tree, "b/00099", "", false)
+	checkValue(t, tree, "b/00100", "", true)
+}
+
+func TestCompactAll(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+	fillCompactionTestTree(t, tree)
+	keys := 3*compactionTestTableSize - 100
+
+	if err := tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	levels := treeLevels(t, tree)
+	if len(levels) != 1 || len(levels[0]) != 1 || len(levels[0][0]) != 1 {
+		t.Fatalf("unexpected layout %+v", levels)
+	}
+	if table := levels[0][0][0]; table.Elements != keys || table.Smallest != "a/00000" || table.Largest != "c/09999" {
+		t.Fatalf("unexpected table %+v", table)
+	}
+	if count := countKeys(t, tree); count != keys {
+		t.Fatalf("%d keys after compaction, expected %d", count, keys)
+	}
+
+	// Compacting a single run without tombstones again is a no-op.
+	if err := tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if again := treeLevels(t, tree); !reflect.DeepEqual(again, levels) {
+		t.Fatalf("second compaction changed the layout to %+v", again)
+	}
+}
+
+// removeAllFilter drops every key it's asked about.
+type removeAllFilter struct{}
+
+func (removeAllFilter) Filter(int, string, string) (lsm_tree.FilterDecision, string) {
+	return lsm_tree.FilterRemove, ""
+}
+
+func TestCompactAllRewritesSingleRun(t *testing.T) {
+	fill := func(t *testing.T, write func(key string) error, tree *lsm_tree.LSMTree) {
+		t.Helper()
+
+		for i := range 4 {
+			if err := write(fmt.Sprintf("key-%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compactAll := func(t *testing.T, tree *lsm_tree.LSMTree) []lsm_tree.TableInfo {
+		t.Helper()
+
+		if err := tree.CompactAll(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		levels := treeLevels(t, tree)
+		if len(levels) != 1 || len(levels[0]) > 1 {
+			t.Fatalf("unexpected layout %+v", levels)
+		}
+		if len(levels[0]) == 0 {
+			return nil
+		}
+		return levels[0][0]
+	}
+
+	t.Run("expired keys", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+		tree := openMemTree(t, lsm_tree.Options{Now: clock.Now})
+		fill(t, func(key string) error { return tree.AddWithTTL(key, time.Hour) }, tree)
+		clock.now = clock.now.Add(2 * time.Hour)
+
+		if tables := compactAll(t, tree); len(tables) != 0 {
+			t.Fatalf("expired keys left in %+v", tables)
+		}
+	})
+
+	t.Run("compaction filter", func(t *testing.T) {
+		tree := openMemTree(t, lsm_tree.Options{CompactionFilter: removeAllFilter{}})
+		fill(t, tree.Add, tree)
+
+		if tables := compactAll(t, tree); len(tables) != 0 {
+			t.Fatalf("keys removed by the filter left in %+v", tables)
+		}
+	})
+
+	t.Run("merge operands", func(t *testing.T) {
+		fs := vfs.NewMemFS()
+		tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, MergeOperator: lsm_tree.UInt64Add})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tree.Close()
+		fill(t, func(key string) error { return tree.Merge(key, "1") }, tree)
+
+		tables := compactAll(t, tree)
+		if len(tables) != 1 {
+			t.Fatalf("unexpected run %+v", tables)
+		}
+		sst, err := sstable.Open(fs, tables[0].MetaPath, tables[0].DataPath, comparator.Bytewise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sst.Close()
+		for i := range sst.Size() {
+			element, err := sst.ElementAt(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if element.IsMergeOperand {
+				t.Fatalf("%s is still an operand after compaction", element.Value)
+			}
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+// numericComparator orders decimal keys by their value, so "9" < "10".
+type numericComparator struct{}
+
+func (numericComparator) Compare(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+func (numericComparator) Name() string {
+	return "test.numeric"
+}
+
+func TestTreeWithCustomComparator(t *testing.T) {
+	fs := vfs.NewMemFS()
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Comparator: numericComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys end up in flushed tables and in the RAM component.
+	expected := make([]string, 0, 300)
+	for i := range 300 {
+		expected = append(expected, strconv.Itoa(i))
+		if err = tree.Add(strconv.Itoa(299 - i)); err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 99 && i < 299 {
+			if err = tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if keys := allKeys(t, tree); !slices.Equal(keys, expected) {
+		t.Fatalf("iteration order %v, expected numeric order", keys)
+	}
+
+	keys, err := tree.SearchRange("9", "11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"9", "10", "11"}) {
+		t.Fatalf("SearchRange(9, 11) = %v", keys)
+	}
+	// "100" < "99" bytewise, but not for the tree.
+	if _, err = tree.SearchRange("100", "99"); !errors.Is(err, lsm_tree.ErrInvalidRange) {
+		t.Fatalf("SearchRange(100, 99) = %v, expected %v", err, lsm_tree.ErrInvalidRange)
+	}
+
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if !errors.Is(err, sstable.ErrComparatorMismatch) {
+		t.Fatalf("reopening with the default comparator: %v, expected %v", err, sstable.ErrComparatorMismatch)
+	}
+
+	tree, err = lsm_tree.Open(lsm_tree.Options{FS: fs, Comparator: numericComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if keys := allKeys(t, tree); !slices.Equal(keys, expected) {
+		t.Fatalf("%d keys in wrong order after reopening", len(keys))
+	}
+}
@@ -0,0 +1,269 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+const (
+	// The last flush fills the first level and triggers a merge.
+	crashTestRounds = common.MaxLevelSize
+	keysPerRound    = 1000
+)
+
+func crashTestKey(round int, i int) string {
+	return fmt.Sprintf("%02d-%06d", round, i)
+}
+
+// runCrashWorkload writes rounds of distinct keys, each followed by a flush,
+// and returns the number of rounds that succeeded.
+func runCrashWorkload(t *testing.T, fs *vfs.FaultFS) int {
+	t.Helper()
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		if !errors.Is(err, vfs.ErrInjectedFault) {
+			t.Fatal(err)
+		}
+		return 0
+	}
+
+	for round := range crashTestRounds {
+		for i := range keysPerRound {
+			if err = tree.Add(crashTestKey(round, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = tree.Flush(); err != nil {
+			if !errors.Is(err, vfs.ErrInjectedFault) {
+				t.Fatal(err)
+			}
+			checkReadOnlyTree(t, tree, round+1)
+			return round
+		}
+	}
+
+	return crashTestRounds
+}
+
+func countKeys(t *testing.T, tree *lsm_tree.LSMTree) int {
+	t.Helper()
+
+	res, err := tree.SearchRangeWithOptions("", "", lsm_tree.SearchRangeOptions{
+		LeftBound:  lsm_tree.Unbounded,
+		RightBound: lsm_tree.Unbounded,
+		CountOnly:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Count
+}
+
+// checkReadOnlyTree verifies that a failed flush or merge left the tree
+// readable with nothing lost, but refusing writes.
+func checkReadOnlyTree(t *testing.T, tree *lsm_tree.LSMTree, writtenRounds int) {
+	t.Helper()
+
+	if tree.Err() == nil {
+		t.Fatal("no background error after a failed flush")
+	}
+	if err := tree.Add("key"); !errors.Is(err, lsm_tree.ErrReadOnly) {
+		t.Fatalf("write after a failed flush returned %v", err)
+	}
+	if count := countKeys(t, tree); count != writtenRounds*keysPerRound {
+		t.Fatalf("found %d keys after a failed flush, expected %d", count, writtenRounds*keysPerRound)
+	}
+}
+
+func checkRecoveredTree(t *testing.T, fs *vfs.FaultFS, completedRounds int) {
+	t.Helper()
+
+	fs.Crash()
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatalf("opening after crash: %v", err)
+	}
+	defer tree.Close()
+
+	// The round interrupted by the crash is either flushed completely or lost.
+	count := countKeys(t, tree)
+	recoveredRounds := count / keysPerRound
+	if count%keysPerRound != 0 || recoveredRounds < completedRounds || recoveredRounds > completedRounds+1 {
+		t.Fatalf("recovered %d keys after %d completed rounds", count, completedRounds)
+	}
+
+	for round := range recoveredRounds {
+		for _, i := range []int{0, keysPerRound / 2, keysPerRound - 1} {
+			ok, err := tree.SearchKey(crashTestKey(round, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatalf("key %s of a flushed round is lost", crashTestKey(round, i))
+			}
+		}
+	}
+}
+
+func TestCrashDuringFlushAndMerge(t *testing.T) {
+	dryRun := vfs.NewFaultFS(vfs.NewMemFS())
+	if rounds := runCrashWorkload(t, dryRun); rounds != crashTestRounds {
+		t.Fatalf("dry run completed %d rounds", rounds)
+	}
+
+	for crashPoint := range dryRun.Writes() + 1 {
+		t.Run(fmt.Sprintf("crash after %d writes", crashPoint), func(t *testing.T) {
+			t.Parallel()
+
+			fs := vfs.NewFaultFS(vfs.NewMemFS())
+			fs.SetWriteLimit(crashPoint)
+			completedRounds := runCrashWorkload(t, fs)
+			checkRecoveredTree(t, fs, completedRounds)
+		})
+	}
+}
+
+func writeRound(t *testing.T, dir string, round int) {
+	t.Helper()
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range keysPerRound {
+		if err = tree.Add(crashTestKey(round, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecoveryAfterInterruptedFlush recreates the files a crash in the middle
+// of a flush leaves: temporary files of the table being written, the
+// complete table not yet in the manifest and a temporary manifest.
+func TestRecoveryAfterInterruptedFlush(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, common.ManifestFile)
+
+	writeRound(t, dir, 0)
+	committed, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRound(t, dir, 1)
+	if err = os.WriteFile(manifestPath, committed, 0660); err != nil {
+		t.Fatal(err)
+	}
+	leftovers := []string{
+		manifestPath + common.TempSuffix,
+		filepath.Join(dir, common.DataDir, "2"+common.TempSuffix),
+		filepath.Join(dir, common.MetaDataDir, "2"+common.TempSuffix),
+	}
+	for _, path := range leftovers {
+		if err = os.WriteFile(path, []byte("partial"), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("opening after crash: %v", err)
+	}
+	defer tree.Close()
+
+	res, err := tree.SearchRangeWithOptions("", "", lsm_tree.SearchRangeOptions{
+		LeftBound:  lsm_tree.Unbounded,
+		RightBound: lsm_tree.Unbounded,
+		CountOnly:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != keysPerRound {
+		t.Fatalf("recovered %d keys, expected only the committed round", res.Count)
+	}
+	ok, err := tree.SearchKey(crashTestKey(0, keysPerRound-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("key of the committed round is lost")
+	}
+
+	// The table of the uncommitted round is removed with the leftovers.
+	leftovers = append(leftovers,
+		filepath.Join(dir, common.DataDir, "1"),
+		filepath.Join(dir, common.MetaDataDir, "1"),
+	)
+	for _, path := range leftovers {
+		if _, err = os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s is left after recovery", path)
+		}
+	}
+}
+
+// TestOpenWithoutManifest opens a directory written before the manifest
+// existed: its tables are adopted, and nothing but temporary files is
+// removed.
+func TestOpenWithoutManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeRound(t, dir, 0)
+	writeRound(t, dir, 1)
+	if err := os.Remove(filepath.Join(dir, common.ManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	foreign := filepath.Join(dir, common.DataDir, "notes.txt")
+	leftover := filepath.Join(dir, common.DataDir, "2"+common.TempSuffix)
+	for _, path := range []string{foreign, leftover} {
+		if err := os.WriteFile(path, []byte("text"), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err := lsm_tree.Open(lsm_tree.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := countKeys(t, tree); count != 2*keysPerRound {
+		t.Fatalf("%d keys after adopting the tables, expected %d", count, 2*keysPerRound)
+	}
+	if _, err = os.Stat(foreign); err != nil {
+		t.Fatalf("a file that isn't a table was touched: %v", err)
+	}
+	if _, err = os.Stat(leftover); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("%s is left after opening", leftover)
+	}
+
+	// New tables don't reuse the numbers of the adopted ones, which are now
+	// in the manifest.
+	if err = tree.Add(crashTestKey(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, common.ManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = lsm_tree.Open(lsm_tree.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if count := countKeys(t, tree); count != 2*keysPerRound+1 {
+		t.Fatalf("%d keys after reopening, expected %d", count, 2*keysPerRound+1)
+	}
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+const dumpTestElements = 2 * common.FirstLevelSize
+
+func allKeys(t *testing.T, tree *lsm_tree.LSMTree) []string {
+	t.Helper()
+
+	res, err := tree.SearchRangeWithOptions("", "", lsm_tree.SearchRangeOptions{
+		LeftBound:  lsm_tree.Unbounded,
+		RightBound: lsm_tree.Unbounded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Keys
+}
+
+func openMemTree(t *testing.T, opts lsm_tree.Options) *lsm_tree.LSMTree {
+	t.Helper()
+
+	opts.FS = vfs.NewMemFS()
+	tree, err := lsm_tree.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tree.Close() })
+	return tree
+}
+
+// exportRandomTree runs the randomized workload of the benchmarks with a
+// share of deletions and values, so the dump is built from flushed tables,
+// merged tables and the RAM component.
+func exportRandomTree(t *testing.T) ([]string, []byte) {
+	t.Helper()
+
+	tree := openMemTree(t, lsm_tree.Options{})
+	added := make([]string, 0, dumpTestElements)
+	for range dumpTestElements {
+		var err error
+		if len(added) > 0 && rand.Intn(4) == 0 {
+			err = tree.Delete(added[rand.Intn(len(added))])
+		} else {
+			s := randString()
+			added = append(added, s)
+			if rand.Intn(2) == 0 {
+				err = tree.Put(s, randString())
+			} else {
+				err = tree.Add(s)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var dump bytes.Buffer
+	if err := tree.Export(&dump); err != nil {
+		t.Fatal(err)
+	}
+	return allKeys(t, tree), dump.Bytes()
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	keys, dump := exportRandomTree(t)
+
+	tree := openMemTree(t, lsm_tree.Options{TargetFileSize: 1 << 20})
+	if err := tree.Import(bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if imported := allKeys(t, tree); !slices.Equal(imported, keys) {
+		t.Fatalf("imported %d keys, exported %d", len(imported), len(keys))
+	}
+
+	var second bytes.Buffer
+	if err := tree.Export(&second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(second.Bytes(), dump) {
+		t.Fatal("dump of the imported tree differs from the original one")
+	}
+}
+
+func TestImportRejectsDamagedDumps(t *testing.T) {
+	_, dump := exportRandomTree(t)
+
+	corrupted := slices.Clone(dump)
+	corrupted[len(corrupted)/2] ^= 0x20
+
+	for name, damaged := range map[string][]byte{
+		"corrupted": corrupted,
+		"truncated": dump[:len(dump)/2],
+		"empty":     nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tree := openMemTree(t, lsm_tree.Options{TargetFileSize: 1 << 20})
+			if err := tree.Import(bytes.NewReader(damaged)); !errors.Is(err, lsm_tree.ErrInvalidDump) {
+				t.Fatalf("import returned %v", err)
+			}
+			if keys := allKeys(t, tree); len(keys) != 0 {
+				t.Fatalf("damaged import added %d keys", len(keys))
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+type eventRecorder struct {
+	events      []string
+	live        map[string]int
+	compactions []lsm_tree.CompactionInfo
+	stalls      int
+	errs        []error
+}
+
+func (r *eventRecorder) listener() lsm_tree.EventListener {
+	return lsm_tree.EventListener{
+		OnFlushBegin: func(info lsm_tree.FlushInfo) {
+			r.events = append(r.events, "flush begin")
+		},
+		OnFlushEnd: func(info lsm_tree.FlushInfo) {
+			r.events = append(r.events, "flush end")
+		},
+		OnCompactionBegin: func(info lsm_tree.CompactionInfo) {
+			r.events = append(r.events, fmt.Sprintf("compaction begin %d", info.Level))
+		},
+		OnCompactionEnd: func(info lsm_tree.CompactionInfo) {
+			r.events = append(r.events, fmt.Sprintf("compaction end %d", info.Level))
+			r.compactions = append(r.compactions, info)
+		},
+		OnTableCreated: func(info lsm_tree.TableInfo) {
+			r.live[info.MetaPath] = info.Level
+		},
+		OnTableDeleted: func(info lsm_tree.TableInfo) {
+			if level, ok := r.live[info.MetaPath]; !ok || level != info.Level {
+				r.errs = append(r.errs, fmt.Errorf("deleted unknown table %+v", info))
+			}
+			delete(r.live, info.MetaPath)
+		},
+		OnWriteStall: func(info lsm_tree.WriteStallInfo) {
+			r.stalls++
+		},
+		OnBackgroundError: func(err error) {
+			r.errs = append(r.errs, err)
+		},
+	}
+}
+
+func TestEventListener(t *testing.T) {
+	recorder := &eventRecorder{live: make(map[string]int)}
+	tree := openMemTree(t, lsm_tree.Options{EventListener: recorder.listener()})
+
+	for round := range common.MaxLevelSize - 1 {
+		if err := tree.Put(fmt.Sprint("round/", round), "value"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The write that fills the memtable flushes it and merges the full level.
+	for i := range common.FirstLevelSize {
+		if err := tree.Add(fmt.Sprintf("key/%05d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := make([]string, 0)
+	for range common.MaxLevelSize {
+		expected = append(expected, "flush begin", "flush end")
+	}
+	expected = append(expected, "compaction begin 1", "compaction end 1")
+	if fmt.Sprint(recorder.events) != fmt.Sprint(expected) {
+		t.Fatalf("got events %v, expected %v", recorder.events, expected)
+	}
+	if len(recorder.errs) != 0 {
+		t.Fatal(recorder.errs)
+	}
+	if recorder.stalls != 1 {
+		t.Fatalf("%d write stalls", recorder.stalls)
+	}
+
+	compaction := recorder.compactions[0]
+	if len(compaction.Inputs) != common.MaxLevelSize || len(compaction.Outputs) != 1 || compaction.BytesWritten == 0 {
+		t.Fatalf("unexpected compaction %+v", compaction)
+	}
+	if len(recorder.live) != 1 || recorder.live[compaction.Outputs[0].MetaPath] != 1 {
+		t.Fatalf("live tables after the compaction are %v", recorder.live)
+	}
+}
+
+func TestEventListenerBackgroundError(t *testing.T) {
+	recorder := &eventRecorder{live: make(map[string]int)}
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, EventListener: recorder.listener()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tree.Add("key"); err != nil {
+		t.Fatal(err)
+	}
+	fs.SetWriteLimit(0)
+	if err = tree.Flush(); err == nil {
+		t.Fatal("flush succeeded without writes")
+	}
+
+	if len(recorder.errs) != 1 || !errors.Is(recorder.errs[0], vfs.ErrInjectedFault) {
+		t.Fatalf("background errors %v", recorder.errs)
+	}
+	if fmt.Sprint(recorder.events) != "[flush begin flush end]" || len(recorder.live) != 0 {
+		t.Fatalf("got events %v and tables %v", recorder.events, recorder.live)
+	}
+}
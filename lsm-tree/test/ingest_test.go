@@ -0,0 +1,214 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/comparator"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+func writeExternalTable(t *testing.T, fs vfs.FS, name string, cmp comparator.Comparator, keys []string, deleted []string) lsm_tree.TablePaths {
+	t.Helper()
+
+	if err := fs.MkdirAll("external", 0755); err != nil {
+		t.Fatal(err)
+	}
+	paths := lsm_tree.TablePaths{Meta: "external/" + name + ".meta", Data: "external/" + name + ".data"}
+	w, err := sstable.NewSSTWriter(fs, paths.Meta, paths.Data, cmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isDeleted := make(map[string]bool)
+	for _, key := range deleted {
+		isDeleted[key] = true
+	}
+	for _, key := range keys {
+		if isDeleted[key] {
+			err = w.Delete(key)
+		} else {
+			err = w.Add(key)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func checkKeys(t *testing.T, tree *lsm_tree.LSMTree, expected map[string]bool) {
+	t.Helper()
+
+	for key, want := range expected {
+		ok, err := tree.SearchKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("SearchKey(%s) = %v, expected %v", key, ok, want)
+		}
+	}
+}
+
+func TestIngest(t *testing.T) {
+	fs := vfs.NewMemFS()
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 0)
+	for i := range 1000 {
+		keys = append(keys, fmt.Sprintf("key-%04d", i))
+	}
+
+	// An older version of the ingested range is on disk, a newer one is in
+	// the RAM component: the ingested table must shadow both.
+	for _, key := range keys[:500] {
+		if err = tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Delete(keys[700]); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Add("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := writeExternalTable(t, fs, "first", comparator.Bytewise, keys[100:800], keys[100:200])
+	second := writeExternalTable(t, fs, "second", comparator.Bytewise, keys[150:160], keys[155:160])
+	if err = tree.Ingest([]lsm_tree.TablePaths{first, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tree doesn't depend on the original files.
+	for _, p := range []lsm_tree.TablePaths{first, second} {
+		if err = fs.Remove(p.Meta); err != nil {
+			t.Fatal(err)
+		}
+		if err = fs.Remove(p.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]bool{"other": true}
+	for i, key := range keys {
+		expected[key] = i < 100 || (150 <= i && i < 155) || (200 <= i && i < 800)
+	}
+	checkKeys(t, tree, expected)
+
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkKeys(t, tree, expected)
+}
+
+func TestIngestRejectsInvalidTables(t *testing.T) {
+	fs := vfs.NewMemFS()
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	w, err := sstable.NewSSTWriter(fs, "unsorted.meta", "unsorted.data", comparator.Bytewise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Add("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Add("a"); !errors.Is(err, sstable.ErrKeysOutOfOrder) {
+		t.Fatalf("adding an unsorted key returned %v", err)
+	}
+	w.Abort()
+
+	reversed := writeExternalTable(t, fs, "reversed", comparator.Reverse(comparator.Bytewise), []string{"b", "a"}, nil)
+	if err = tree.Ingest([]lsm_tree.TablePaths{reversed}); !errors.Is(err, sstable.ErrComparatorMismatch) {
+		t.Fatalf("ingesting a table with another comparator returned %v", err)
+	}
+
+	missing := lsm_tree.TablePaths{Meta: "missing.meta", Data: "missing.data"}
+	if err = tree.Ingest([]lsm_tree.TablePaths{missing}); err == nil {
+		t.Fatal("ingesting a missing table succeeded")
+	}
+
+	if err = tree.Add("a"); err != nil {
+		t.Fatalf("tree isn't writable after a rejected ingestion: %v", err)
+	}
+}
+
+// crossDeviceFS fails every link like a link to another device does.
+type crossDeviceFS struct {
+	vfs.FS
+}
+
+func (crossDeviceFS) Link(oldname string, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+}
+
+func TestLinkOrCopy(t *testing.T) {
+	mem := vfs.NewMemFS()
+	if err := mem.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir/source", "dir/existing"} {
+		file, err := mem.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = file.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		if err = file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only a file system that can't link falls back to a copy; a missing
+	// source or an existing target are errors either way.
+	for _, fs := range []vfs.FS{mem, crossDeviceFS{FS: mem}} {
+		if err := vfs.LinkOrCopy(fs, "dir/missing", "dir/target"); !errors.Is(err, iofs.ErrNotExist) {
+			t.Fatalf("linking a missing file = %v, expected %v", err, iofs.ErrNotExist)
+		}
+	}
+	if err := vfs.LinkOrCopy(mem, "dir/source", "dir/existing"); !errors.Is(err, iofs.ErrExist) {
+		t.Fatalf("linking over an existing file = %v, expected %v", err, iofs.ErrExist)
+	}
+
+	if err := vfs.LinkOrCopy(crossDeviceFS{FS: mem}, "dir/source", "dir/copy"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := mem.Open("dir/copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "dir/source" {
+		t.Fatalf("copy holds %q", content)
+	}
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+)
+
+func TestLevels(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	// The last flush merges the first level into a single run of the second.
+	for round := range common.MaxLevelSize + 1 {
+		for i := range 10 {
+			if err := tree.Add(fmt.Sprintf("key/%d/%d", round, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	levels, err := tree.Levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 2 || len(levels[0]) != 1 || len(levels[1]) != 1 || len(levels[1][0]) != 1 {
+		t.Fatalf("unexpected layout %+v", levels)
+	}
+
+	newest := levels[0][0][0]
+	if newest.Level != 0 || newest.Elements != 10 || newest.Smallest != "key/5/0" || newest.Largest != "key/5/9" {
+		t.Fatalf("unexpected table %+v", newest)
+	}
+	merged := levels[1][0][0]
+	if merged.Level != 1 || merged.Elements != 10*common.MaxLevelSize || merged.Smallest != "key/0/0" || merged.FileSize == 0 {
+		t.Fatalf("unexpected table %+v", merged)
+	}
+}
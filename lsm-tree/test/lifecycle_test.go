@@ -0,0 +1,239 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+func TestClosedTree(t *testing.T) {
+	fs := vfs.NewMemFS()
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Add("flushed"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Add("in memory"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	operations := map[string]func() error{
+		"Add":    func() error { return tree.Add("key") },
+		"Delete": func() error { return tree.Delete("key") },
+		"SearchKey": func() error {
+			_, err := tree.SearchKey("key")
+			return err
+		},
+		"SearchRange": func() error {
+			_, err := tree.SearchRange("a", "z")
+			return err
+		},
+		"ApproximateSize": func() error {
+			_, err := tree.ApproximateSize("a", "z")
+			return err
+		},
+		"ApproximateCount": func() error {
+			_, err := tree.ApproximateCount("a", "z")
+			return err
+		},
+		"CompactRange": func() error { return tree.CompactRange(ctx, "a", "z", nil) },
+		"CompactAll":   func() error { return tree.CompactAll(ctx, nil) },
+		"Flush":        tree.Flush,
+		"Clear":        tree.Clear,
+		"Close":        tree.Close,
+	}
+	for name, operation := range operations {
+		if err = operation(); !errors.Is(err, lsm_tree.ErrClosed) {
+			t.Errorf("%s after Close = %v, expected %v", name, err, lsm_tree.ErrClosed)
+		}
+	}
+
+	// Close flushed the RAM component.
+	tree, err = lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if count := countKeys(t, tree); count != 2 {
+		t.Fatalf("%d keys after reopening, expected 2", count)
+	}
+}
+
+func TestClear(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+	for _, key := range []string{"a", "b"} {
+		if err := tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Add("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countKeys(t, tree); count != 0 {
+		t.Fatalf("%d keys after Clear", count)
+	}
+	levels := treeLevels(t, tree)
+	if len(levels) != 1 || len(levels[0]) != 0 {
+		t.Fatalf("tables left after Clear: %+v", levels)
+	}
+
+	// The tree stays usable, and a flush doesn't bring back the cleared RAM
+	// component.
+	if err := tree.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := allKeys(t, tree); len(keys) != 1 || keys[0] != "d" {
+		t.Fatalf("keys %v after Clear and a flush, expected [d]", keys)
+	}
+}
+
+func TestCloseAfterFailures(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err = tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+		if key != "c" {
+			if err = tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Both the flush and the syncs of the tables fail, but every table is
+	// closed anyway.
+	fs.SetWriteLimit(0)
+	err = tree.Close()
+	if !errors.Is(err, lsm_tree.ErrFlushingRAMComponent) || !errors.Is(err, lsm_tree.ErrClosingSSTable) {
+		t.Fatalf("Close = %v, expected flush and table errors", err)
+	}
+	if err = tree.Close(); !errors.Is(err, lsm_tree.ErrClosed) {
+		t.Fatalf("second Close = %v, expected %v", err, lsm_tree.ErrClosed)
+	}
+}
+
+func TestReadOnlyTree(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err = tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+		if err = tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A file left by an interrupted flush, which a writable tree removes.
+	leftover := filepath.Join(common.DataDir, "9"+common.TempSuffix)
+	file, err := fs.Create(leftover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	// Every modifying operation on the file system fails from now on.
+	writes := fs.Writes()
+	fs.SetWriteLimit(0)
+	tree, err = lsm_tree.Open(lsm_tree.Options{FS: fs, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := allKeys(t, tree); !slices.Equal(keys, []string{"a", "b"}) {
+		t.Fatalf("keys %v, expected [a b]", keys)
+	}
+
+	ctx := context.Background()
+	operations := map[string]func() error{
+		"Add":        func() error { return tree.Add("c") },
+		"Delete":     func() error { return tree.Delete("a") },
+		"Flush":      tree.Flush,
+		"Clear":      tree.Clear,
+		"CompactAll": func() error { return tree.CompactAll(ctx, nil) },
+	}
+	for name, operation := range operations {
+		if err = operation(); !errors.Is(err, lsm_tree.ErrReadOnly) {
+			t.Errorf("%s on a read-only tree = %v, expected %v", name, err, lsm_tree.ErrReadOnly)
+		}
+	}
+
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Writes() != writes {
+		t.Fatalf("%d writes to the file system of a read-only tree", fs.Writes()-writes)
+	}
+	if _, err = fs.Open(leftover); err != nil {
+		t.Fatalf("the read-only tree removed %s: %v", leftover, err)
+	}
+}
+
+func TestCloseRetriesFailedFlush(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed flush switches the tree to the read-only mode but keeps the
+	// acknowledged write, which Close flushes once the disk recovers.
+	fs.SetWriteLimit(0)
+	if err = tree.Flush(); !errors.Is(err, lsm_tree.ErrFlushingRAMComponent) {
+		t.Fatalf("Flush = %v, expected %v", err, lsm_tree.ErrFlushingRAMComponent)
+	}
+	if err = tree.Put("rejected", "value"); !errors.Is(err, lsm_tree.ErrReadOnly) {
+		t.Fatalf("Put after a failed flush = %v, expected %v", err, lsm_tree.ErrReadOnly)
+	}
+	fs.SetWriteLimit(-1)
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = lsm_tree.Open(lsm_tree.Options{FS: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkValue(t, tree, "key", "value", true)
+	checkValue(t, tree, "rejected", "", false)
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+func TestLogging(t *testing.T) {
+	logs := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := range common.MaxLevelSize {
+		if err = tree.Add(string(rune('a' + round))); err != nil {
+			t.Fatal(err)
+		}
+		if err = tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clear can't return errors, they are only logged.
+	fs.SetWriteLimit(0)
+	tree.Clear()
+
+	for _, record := range []string{
+		`level=INFO msg="opened lsm tree" dir=. recovered=false`,
+		`level=INFO msg="flushed memtable" keys=1`,
+		`level=INFO msg="compaction finished" level=1 inputs=5 outputs=1`,
+		`level=DEBUG msg="removed table"`,
+		`level=WARN msg="removing table failed"`,
+		`level=WARN msg="removing manifest failed"`,
+	} {
+		if !strings.Contains(logs.String(), record) {
+			t.Fatalf("logs don't contain %q:\n%s", record, logs.String())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/merge_operator"
+)
+
+func TestMergeOperatorCounters(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{MergeOperator: lsm_tree.UInt64Add})
+
+	if err := tree.Put("base", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put("deleted", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Operands of every round are flushed into their own run, and the merge
+	// into the second level only partially merges the operands of counters
+	// without a base.
+	for round := range 2 * common.MaxLevelSize {
+		for i := range 100 {
+			if err := tree.Merge(fmt.Sprintf("counter/%03d", i), fmt.Sprint(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, key := range []string{"base", "deleted"} {
+			if err := tree.Merge(key, "1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		checkValue(t, tree, "counter/007", fmt.Sprint(7*(round+1)), true)
+		checkValue(t, tree, "base", fmt.Sprint(100+round+1), true)
+		checkValue(t, tree, "deleted", fmt.Sprint(round+1), true)
+	}
+
+	if err := tree.Merge("base", "5"); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, tree, "base", fmt.Sprint(100+2*common.MaxLevelSize+5), true)
+
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, tree, "counter/099", fmt.Sprint(99*2*common.MaxLevelSize), true)
+	checkValue(t, tree, "base", fmt.Sprint(100+2*common.MaxLevelSize+5), true)
+	checkValue(t, tree, "deleted", fmt.Sprint(2*common.MaxLevelSize), true)
+	if count := countKeys(t, tree); count != 102 {
+		t.Fatalf("%d keys left after compaction", count)
+	}
+
+	if err := tree.Merge("base", "not a number"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.Get("base"); !errors.Is(err, merge_operator.ErrInvalidOperand) {
+		t.Fatalf("Get with an invalid operand returned %v", err)
+	}
+}
+
+func TestMergeOperatorStringAppend(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{MergeOperator: lsm_tree.StringAppend(",")})
+
+	for i, word := range []string{"a", "b", "c", "d"} {
+		if err := tree.Merge("list", word); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checkValue(t, tree, "list", "a,b,c,d", true)
+
+	// Range searches fold the operands spread over the tables too.
+	res, err := tree.SearchRangeWithOptions("list", "list", lsm_tree.SearchRangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Values) != 1 || res.Values[0] != "a,b,c,d" {
+		t.Fatalf("SearchRange values = %q, expected [a,b,c,d]", res.Values)
+	}
+
+	if err := tree.Put("list", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Merge("list", "y"); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, tree, "list", "x,y", true)
+
+	if err := tree.Delete("list"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Merge("list", "z"); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, tree, "list", "z", true)
+}
+
+func TestMergeWithoutOperator(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	if err := tree.Merge("key", "1"); !errors.Is(err, merge_operator.ErrNoMergeOperator) {
+		t.Fatalf("Merge without an operator returned %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/vfs"
+)
+
+const mergeTestRoundSize = 8000
+
+// runMergeWorkload fills the first level so that it's merged and checks the
+// result. It returns the number of tables left after the merge.
+func runMergeWorkload(t *testing.T, opts lsm_tree.Options) int {
+	t.Helper()
+
+	fs := vfs.NewMemFS()
+	opts.FS = fs
+	tree, err := lsm_tree.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// Every round rewrites the keys of the previous one and deletes every
+	// third of them, so the merge sees overlapping inputs, shadowed versions
+	// and tombstones.
+	expected := make(map[string]struct{})
+	for round := range common.MaxLevelSize {
+		for i := range mergeTestRoundSize * (common.MaxLevelSize - round) {
+			key := fmt.Sprintf("%06d", i)
+			if i%3 == round%3 {
+				err = tree.Delete(key)
+				delete(expected, key)
+			} else {
+				err = tree.Add(key)
+				expected[key] = struct{}{}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, reverse := range []bool{false, true} {
+		res, err := tree.SearchRangeWithOptions("", "", lsm_tree.SearchRangeOptions{
+			LeftBound:  lsm_tree.Unbounded,
+			RightBound: lsm_tree.Unbounded,
+			Reverse:    reverse,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := slices.Clone(keys)
+		if reverse {
+			slices.Reverse(want)
+		}
+		if !slices.Equal(res.Keys, want) {
+			t.Fatalf("range with reverse=%v returned %d keys, expected %d", reverse, len(res.Keys), len(want))
+		}
+	}
+
+	for i := 0; i < mergeTestRoundSize*common.MaxLevelSize; i += 7 {
+		key := fmt.Sprintf("%06d", i)
+		ok, err := tree.SearchKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, want := expected[key]; ok != want {
+			t.Fatalf("SearchKey(%s) = %v, expected %v", key, ok, want)
+		}
+	}
+
+	tables, err := fs.ReadDir(common.MetaDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(tables)
+}
+
+func TestMergeSplitsOutput(t *testing.T) {
+	// Without splitting there would be a single table after the merge.
+	if tables := runMergeWorkload(t, lsm_tree.Options{TargetFileSize: 16 << 10}); tables <= common.MaxLevelSize {
+		t.Fatalf("merge produced %d tables", tables)
+	}
+}
+
+func TestSubcompactions(t *testing.T) {
+	if tables := runMergeWorkload(t, lsm_tree.Options{MaxSubcompactions: 4}); tables < 2 {
+		t.Fatalf("merge produced %d tables", tables)
+	}
+}
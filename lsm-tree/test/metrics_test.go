@@ -0,0 +1,75 @@
+package test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+)
+
+func TestMetrics(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	// The last flush fills the first level and merges it into the second one.
+	for round := range common.MaxLevelSize {
+		for i := range 100 {
+			if err := tree.Put(fmt.Sprintf("key/%d/%03d", round, i), "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Put("memtable", "value"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		checkValue(t, tree, fmt.Sprintf("key/0/%03d", i), "value", true)
+		checkValue(t, tree, fmt.Sprintf("key/1/%03d/absent", i), "", false)
+	}
+
+	m, err := tree.Metrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Levels) != 2 || m.Levels[0].Tables != 0 || m.Levels[1].Runs != 1 || m.Levels[1].Bytes == 0 {
+		t.Fatalf("unexpected levels %+v", m.Levels)
+	}
+	if m.MemtableKeys != 1 || m.MemtableBytes != int64(len("memtable")+len("value")+1) {
+		t.Fatalf("memtable has %d keys and %d bytes", m.MemtableKeys, m.MemtableBytes)
+	}
+	if m.Flushes != common.MaxLevelSize || m.Compactions != 1 {
+		t.Fatalf("%d flushes and %d compactions", m.Flushes, m.Compactions)
+	}
+	if m.CompactionBytesRead != m.FlushBytes || m.CompactionBytesWritten != m.Levels[1].Bytes {
+		t.Fatalf("compactions read %d of %d flushed bytes and wrote %d", m.CompactionBytesRead, m.FlushBytes, m.CompactionBytesWritten)
+	}
+	if m.WriteAmplification <= 1 {
+		t.Fatalf("write amplification is %f", m.WriteAmplification)
+	}
+	if m.BloomFilterUseful == 0 || m.BloomFilterUseful+m.BloomFilterFalsePositive != 100 {
+		t.Fatalf("bloom filter was useful %d times with %d false positives", m.BloomFilterUseful, m.BloomFilterFalsePositive)
+	}
+	if m.ReadLatency.Count != 200 || m.ReadLatency.Buckets[len(m.ReadLatency.Buckets)-1].Count > 200 {
+		t.Fatalf("unexpected read latency histogram %+v", m.ReadLatency)
+	}
+
+	recorder := httptest.NewRecorder()
+	lsm_tree.MetricsHandler(tree).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE lsm_level_tables gauge",
+		fmt.Sprintf("lsm_flushes_total %d", common.MaxLevelSize),
+		`lsm_level_runs{level="1"} 1`,
+		`lsm_read_latency_seconds_bucket{le="+Inf"} 200`,
+		"lsm_read_latency_seconds_count 200",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metrics don't contain %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+)
+
+func keySequence(from int, to int, step int) []string {
+	keys := make([]string, 0)
+	for i := from; step > 0 && i <= to || step < 0 && i >= to; i += step {
+		keys = append(keys, fmt.Sprintf("%02d", i))
+	}
+	return keys
+}
+
+func TestSearchRangeOptions(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	// Keys 00-99 spread over two tables and the RAM component, with 05 and
+	// 50 deleted in the RAM component.
+	for i := range 100 {
+		if err := tree.Add(fmt.Sprintf("%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 49 || i == 79 {
+			if err := tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, key := range []string{"05", "50"} {
+		if err := tree.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		keyL, keyR string
+		opts       lsm_tree.SearchRangeOptions
+		expected   []string
+	}{
+		{
+			name: "inclusive", keyL: "10", keyR: "14",
+			expected: keySequence(10, 14, 1),
+		},
+		{
+			name: "exclusive", keyL: "10", keyR: "14",
+			opts:     lsm_tree.SearchRangeOptions{LeftBound: lsm_tree.Exclusive, RightBound: lsm_tree.Exclusive},
+			expected: keySequence(11, 13, 1),
+		},
+		{
+			name: "single key", keyL: "10", keyR: "10",
+			expected: []string{"10"},
+		},
+		{
+			name: "empty exclusive range", keyL: "10", keyR: "10",
+			opts: lsm_tree.SearchRangeOptions{LeftBound: lsm_tree.Exclusive},
+		},
+		{
+			name: "deleted key", keyL: "05", keyR: "05",
+		},
+		{
+			name: "outside the keys", keyL: "a", keyR: "b",
+		},
+		{
+			name: "between the keys", keyL: "10a", keyR: "10z",
+		},
+		{
+			name: "unbounded left", keyL: "ignored", keyR: "03",
+			opts:     lsm_tree.SearchRangeOptions{LeftBound: lsm_tree.Unbounded},
+			expected: keySequence(0, 3, 1),
+		},
+		{
+			name: "unbounded right", keyL: "97", keyR: "",
+			opts:     lsm_tree.SearchRangeOptions{LeftBound: lsm_tree.Exclusive, RightBound: lsm_tree.Unbounded},
+			expected: keySequence(98, 99, 1),
+		},
+		{
+			name: "reverse", keyL: "10", keyR: "14",
+			opts:     lsm_tree.SearchRangeOptions{Reverse: true},
+			expected: keySequence(14, 10, -1),
+		},
+		{
+			name: "offset and limit", keyL: "10", keyR: "19",
+			opts:     lsm_tree.SearchRangeOptions{Offset: 2, Limit: 2},
+			expected: []string{"12", "13"},
+		},
+		{
+			name: "reverse offset and limit", keyL: "10", keyR: "19",
+			opts:     lsm_tree.SearchRangeOptions{Offset: 1, Limit: 2, Reverse: true},
+			expected: []string{"18", "17"},
+		},
+		{
+			name: "offset skips deleted keys", keyL: "00", keyR: "09",
+			opts:     lsm_tree.SearchRangeOptions{Offset: 5},
+			expected: keySequence(6, 9, 1),
+		},
+		{
+			name: "offset at the end", keyL: "10", keyR: "14",
+			opts: lsm_tree.SearchRangeOptions{Offset: 5},
+		},
+		{
+			name: "offset past the end", keyL: "10", keyR: "14",
+			opts: lsm_tree.SearchRangeOptions{Offset: 100},
+		},
+		{
+			name: "limit 0 is no limit", keyL: "48", keyR: "52",
+			opts:     lsm_tree.SearchRangeOptions{Limit: 0},
+			expected: []string{"48", "49", "51", "52"},
+		},
+		{
+			name: "limit past the end", keyL: "98", keyR: "99",
+			opts:     lsm_tree.SearchRangeOptions{Limit: 10},
+			expected: []string{"98", "99"},
+		},
+		{
+			name: "everything", keyL: "", keyR: "",
+			opts:     lsm_tree.SearchRangeOptions{LeftBound: lsm_tree.Unbounded, RightBound: lsm_tree.Unbounded},
+			expected: slices.DeleteFunc(keySequence(0, 99, 1), func(key string) bool { return key == "05" || key == "50" }),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tree.SearchRangeWithOptions(tt.keyL, tt.keyR, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(res.Keys, tt.expected) || res.Count != len(tt.expected) {
+				t.Fatalf("got %v (count %d), expected %v", res.Keys, res.Count, tt.expected)
+			}
+
+			countOpts := tt.opts
+			countOpts.CountOnly = true
+			res, err = tree.SearchRangeWithOptions(tt.keyL, tt.keyR, countOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Keys != nil || res.Count != len(tt.expected) {
+				t.Fatalf("count mode returned %d keys and count %d, expected count %d", len(res.Keys), res.Count, len(tt.expected))
+			}
+		})
+	}
+}
+
+func TestSearchRangeInvalidOptions(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	for _, tt := range []struct {
+		name       string
+		keyL, keyR string
+		opts       lsm_tree.SearchRangeOptions
+	}{
+		{name: "reversed bounds", keyL: "b", keyR: "a"},
+		{name: "reversed exclusive bounds", keyL: "b", keyR: "a", opts: lsm_tree.SearchRangeOptions{LeftBound: lsm_tree.Exclusive}},
+		{name: "negative offset", keyL: "a", keyR: "b", opts: lsm_tree.SearchRangeOptions{Offset: -1}},
+		{name: "negative limit", keyL: "a", keyR: "b", opts: lsm_tree.SearchRangeOptions{Limit: -1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tree.SearchRangeWithOptions(tt.keyL, tt.keyR, tt.opts)
+			if !errors.Is(err, lsm_tree.ErrInvalidRange) {
+				t.Fatalf("got %v, expected %v", err, lsm_tree.ErrInvalidRange)
+			}
+		})
+	}
+
+	// Reversed keys are fine when one of the sides is unbounded.
+	opts := lsm_tree.SearchRangeOptions{RightBound: lsm_tree.Unbounded}
+	if _, err := tree.SearchRangeWithOptions("b", "a", opts); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/comparator"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+func TestReadStats(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+
+	// Three runs: two with disjoint key ranges and one covering both.
+	for _, prefix := range []string{"a", "b", ""} {
+		for i := range 100 {
+			if err := tree.Add(fmt.Sprintf("%s%03d", prefix, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if prefix == "" {
+			if err := tree.Add("c"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, stats, err := tree.SearchKeyWithStats("a050")
+	if err != nil || !found {
+		t.Fatalf("SearchKeyWithStats(a050) = %v, %v", found, err)
+	}
+	if stats.TablesConsidered != 3 || stats.TablesSkippedByRange != 1 || stats.Probes == 0 || stats.BytesRead == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Stats attached to a context accumulate over reads.
+	total := lsm_tree.ReadStats{}
+	ctx := lsm_tree.WithReadStats(context.Background(), &total)
+	for i := range 100 {
+		if found, err = tree.SearchKeyContext(ctx, fmt.Sprintf("a%03d/absent", i)); err != nil || found {
+			t.Fatalf("SearchKeyContext = %v, %v", found, err)
+		}
+	}
+	checked := total.TablesConsidered - total.TablesSkippedByRange
+	if total.TablesConsidered != 300 || checked != total.BloomNegatives+total.BloomFalsePositives || total.BloomNegatives == 0 {
+		t.Fatalf("unexpected stats %+v", total)
+	}
+}
+
+func TestSSTableReadStats(t *testing.T) {
+	fs := vfs.NewMemFS()
+	keys := make([]string, 0)
+	for i := range 1024 {
+		keys = append(keys, fmt.Sprintf("key%04d", i))
+	}
+	paths := writeExternalTable(t, fs, "table", comparator.Bytewise, keys, keys[:1])
+	table, err := sstable.Open(fs, paths.Meta, paths.Data, comparator.Bytewise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	stats := sstable.ReadStats{}
+	if res, err := table.SearchKeyWithStats("key0000", &stats); err != nil || res != sstable.SearchResultRemoved {
+		t.Fatalf("SearchKeyWithStats(key0000) = %v, %v", res, err)
+	}
+	if stats.Probes != 10 || stats.BytesRead == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	stats = sstable.ReadStats{}
+	if res, err := table.SearchKeyWithStats("zzz", &stats); err != nil || res != sstable.SearchResultNotFound {
+		t.Fatalf("SearchKeyWithStats(zzz) = %v, %v", res, err)
+	}
+	if stats != (sstable.ReadStats{TablesConsidered: 1, TablesSkippedByRange: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
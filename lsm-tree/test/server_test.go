@@ -0,0 +1,364 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hw1/client"
+	"hw1/cmd/lsm_tree"
+	"hw1/server"
+)
+
+// startServer serves the tree on a loopback port until the test ends.
+func startServer(t *testing.T, tree *lsm_tree.LSMTree) (*server.Server, string, <-chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(tree, server.Options{})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s, l.Addr().String(), served
+}
+
+func dialClient(t *testing.T, addr string) *client.Client {
+	t.Helper()
+
+	c, err := client.Dial(context.Background(), addr, client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func checkRemoteValue(t *testing.T, c *client.Client, key string, value string, present bool) {
+	t.Helper()
+
+	got, ok, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != present || got != value {
+		t.Fatalf("Get(%s) = %q, %v, expected %q, %v", key, got, ok, value, present)
+	}
+}
+
+func formatKeyValues(kvs []client.KeyValue) string {
+	parts := make([]string, len(kvs))
+	for i, kv := range kvs {
+		parts[i] = kv.Key + "=" + kv.Value
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestServer(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+	_, addr, _ := startServer(t, tree)
+	c := dialClient(t, addr)
+	ctx := context.Background()
+
+	for i := range 100 {
+		if err := c.Put(ctx, fmt.Sprintf("key/%03d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Delete(ctx, "key/010", "key/011"); err != nil {
+		t.Fatal(err)
+	}
+	checkRemoteValue(t, c, "key/005", "5", true)
+	checkRemoteValue(t, c, "key/010", "", false)
+	checkValue(t, tree, "key/099", "99", true)
+
+	kvs, err := c.Scan(ctx, "key/008", "key/013", client.ScanOptions{RightBound: lsm_tree.Exclusive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := formatKeyValues(kvs), "key/008=8 key/009=9 key/012=12"; got != expected {
+		t.Fatalf("Scan = %s, expected %s", got, expected)
+	}
+	kvs, err = c.Scan(ctx, "", "", client.ScanOptions{
+		LeftBound:  lsm_tree.Unbounded,
+		RightBound: lsm_tree.Unbounded,
+		Limit:      2,
+		Reverse:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := formatKeyValues(kvs), "key/099=99 key/098=98"; got != expected {
+		t.Fatalf("Scan = %s, expected %s", got, expected)
+	}
+
+	b := &client.Batch{}
+	b.Put("key/010", "new")
+	b.Delete("key/000")
+	b.Put("key/000", "recreated")
+	b.Delete("key/001", "key/002")
+	if err = c.Apply(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	checkRemoteValue(t, c, "key/010", "new", true)
+	checkRemoteValue(t, c, "key/000", "recreated", true)
+	checkRemoteValue(t, c, "key/002", "", false)
+
+	// Reads of a failing tree are reported as server errors.
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.Get(ctx, "key/005"); !errors.Is(err, client.ErrServer) {
+		t.Fatalf("Get = %v, expected %v", err, client.ErrServer)
+	}
+}
+
+func TestServerPipelining(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+	_, addr, _ := startServer(t, tree)
+	c := dialClient(t, addr)
+
+	p := c.Pipeline()
+	for i := range 1000 {
+		p.Put(fmt.Sprintf("key/%04d", i), fmt.Sprint(i))
+		p.Get(fmt.Sprintf("key/%04d", i))
+	}
+	p.Delete("key/0000")
+	p.Get("key/0000")
+	results, err := p.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2002 || p.Len() != 0 {
+		t.Fatalf("%d results, %d commands left", len(results), p.Len())
+	}
+	for i := range 1000 {
+		if r := results[2*i]; r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if r := results[2*i+1]; r.Err != nil || !r.Found || r.Value != fmt.Sprint(i) {
+			t.Fatalf("result %d: %+v", 2*i+1, r)
+		}
+	}
+	if r := results[2001]; r.Err != nil || r.Found {
+		t.Fatalf("deleted key: %+v", r)
+	}
+
+	// Several clients share the tree, each with several connections.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				if err := c.Put(context.Background(), fmt.Sprintf("worker/%d/%02d", worker, i), "x"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err = range errs {
+		t.Fatal(err)
+	}
+	res, err := tree.SearchRangeWithOptions("worker/", "worker/~", lsm_tree.SearchRangeOptions{CountOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 400 {
+		t.Fatalf("%d keys written by the workers, expected 400", res.Count)
+	}
+
+	// Scans return pages of a bounded size.
+	all := client.ScanOptions{LeftBound: lsm_tree.Unbounded, RightBound: lsm_tree.Unbounded}
+	kvs, err := c.Scan(context.Background(), "", "", all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// key/0000 was deleted.
+	if len(kvs) != 1000 || kvs[0].Key != "key/0001" || kvs[999].Key != "worker/0/00" {
+		t.Fatalf("first page has %d keys", len(kvs))
+	}
+	kvs, err = c.Scan(context.Background(), kvs[999].Key, "", client.ScanOptions{
+		LeftBound:  lsm_tree.Exclusive,
+		RightBound: lsm_tree.Unbounded,
+		Limit:      10000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 399 || kvs[0].Key != "worker/0/01" {
+		t.Fatalf("second page has %d keys", len(kvs))
+	}
+	all.Limit = 10001
+	if _, err = c.Scan(context.Background(), "", "", all); !errors.Is(err, client.ErrServer) {
+		t.Fatalf("Scan = %v, expected %v", err, client.ErrServer)
+	}
+}
+
+// TestServerProtocol talks to the server like redis-cli and telnet do.
+func TestServerProtocol(t *testing.T) {
+	tree := openMemTree(t, lsm_tree.Options{})
+	_, addr, _ := startServer(t, tree)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Inline and array commands, all pipelined in one write.
+	requests := "SET a 1\r\n" +
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n" +
+		"GET missing\r\n" +
+		"\r\n" +
+		"NOSUCH\r\n" +
+		"GET\r\n" +
+		"MULTI\r\nSET b 2\r\nGET b\r\nEXEC\r\n" +
+		"MULTI\r\nSET b 2\r\nDEL a c\r\nEXEC\r\n" +
+		"SCAN - + LIMIT 5\r\n" +
+		"SCAN a z\r\n" +
+		"QUIT\r\n" +
+		"PING\r\n"
+	if _, err = conn.Write([]byte(requests)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "+OK\r\n" +
+		"$1\r\n1\r\n" +
+		"$-1\r\n" +
+		"-ERR unknown command 'NOSUCH'\r\n" +
+		"-ERR wrong number of arguments for 'get' command\r\n" +
+		"+OK\r\n+QUEUED\r\n-ERR only SET and DEL can be used in MULTI\r\n" +
+		"-EXECABORT Transaction discarded because of previous errors.\r\n" +
+		"+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n:2\r\n" +
+		"*2\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+		"-ERR min or max not valid string range item\r\n" +
+		"+OK\r\n"
+	if err = conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The connection is closed after QUIT, so PING isn't answered.
+	if string(got) != expected {
+		t.Fatalf("replies:\n%q\nexpected:\n%q", got, expected)
+	}
+
+	// A malformed command is answered with an error and the connection
+	// is closed.
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("*1\r\n$100000000000\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got), "-ERR protocol error") {
+		t.Fatalf("reply %q", got)
+	}
+}
+
+// gateTracer holds the tree lock in the first traced operation until open
+// is closed.
+type gateTracer struct {
+	once    sync.Once
+	blocked chan struct{}
+	open    chan struct{}
+}
+
+func (g *gateTracer) Trace(lsm_tree.OperationTrace) {
+	g.once.Do(func() {
+		close(g.blocked)
+		<-g.open
+	})
+}
+
+func TestServerShutdown(t *testing.T) {
+	tracer := &gateTracer{blocked: make(chan struct{}), open: make(chan struct{})}
+	tree := openMemTree(t, lsm_tree.Options{Tracer: tracer})
+	s, addr, served := startServer(t, tree)
+	c := dialClient(t, addr)
+	idle := dialClient(t, addr)
+
+	// The write is in progress when the shutdown starts.
+	putDone := make(chan error, 1)
+	go func() { putDone <- c.Put(context.Background(), "key", "value") }()
+	<-tracer.blocked
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-served:
+		if !errors.Is(err, server.ErrServerClosed) {
+			t.Fatalf("Serve = %v, expected %v", err, server.ErrServerClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+	}
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown returned %v before the write finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(tracer.open)
+	if err := <-putDone; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-shutdownDone; err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, tree, "key", "value", true)
+
+	// The idle connection was closed and no new ones are accepted.
+	if err := idle.Ping(context.Background()); err == nil {
+		t.Fatal("Ping succeeded after the shutdown")
+	}
+	if _, err := client.Dial(context.Background(), addr, client.Options{}); err == nil {
+		t.Fatal("Dial succeeded after the shutdown")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	tracer := &gateTracer{blocked: make(chan struct{}), open: make(chan struct{})}
+	tree := openMemTree(t, lsm_tree.Options{Tracer: tracer})
+	s, addr, _ := startServer(t, tree)
+	c := dialClient(t, addr)
+
+	putDone := make(chan error, 1)
+	go func() { putDone <- c.Put(context.Background(), "key", "value") }()
+	<-tracer.blocked
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, expected %v", err, context.DeadlineExceeded)
+	}
+	// The connection was closed under the blocked write.
+	if err := <-putDone; err == nil {
+		t.Fatal("Put succeeded on a closed connection")
+	}
+	close(tracer.open)
+}
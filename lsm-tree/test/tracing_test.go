@@ -0,0 +1,150 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+)
+
+type recordingTracer struct {
+	traces []lsm_tree.OperationTrace
+	// If block is set, the tracer signals blocked and holds the tree lock
+	// until block is closed.
+	block   chan struct{}
+	blocked chan struct{}
+}
+
+func (r *recordingTracer) Trace(trace lsm_tree.OperationTrace) {
+	r.traces = append(r.traces, trace)
+	if r.block != nil {
+		close(r.blocked)
+		<-r.block
+	}
+}
+
+func (r *recordingTracer) last() lsm_tree.OperationTrace {
+	return r.traces[len(r.traces)-1]
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	tree := openMemTree(t, lsm_tree.Options{Tracer: tracer})
+
+	for round := range 3 {
+		for i := range 100 {
+			if err := tree.Put(fmt.Sprintf("key/%03d", i), fmt.Sprint(round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if trace := tracer.last(); trace.Operation != lsm_tree.OperationPut || trace.Key != "key/099" || trace.Err != nil {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+
+	checkValue(t, tree, "key/050", "2", true)
+	if trace := tracer.last(); trace.Operation != lsm_tree.OperationGet || trace.TablesProbed != 1 || trace.RecordsRead == 0 {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+
+	skips := 0
+	for i := range 99 {
+		if _, err := tree.SearchKeyContext(context.Background(), fmt.Sprintf("key/%03d/absent", i)); err != nil {
+			t.Fatal(err)
+		}
+		trace := tracer.last()
+		if trace.Operation != lsm_tree.OperationSearchKey || trace.TablesProbed != 3 {
+			t.Fatalf("unexpected trace %+v", trace)
+		}
+		skips += trace.BloomFilterSkips
+	}
+	if skips == 0 {
+		t.Fatal("bloom filters skipped no tables")
+	}
+
+	res, err := tree.SearchRangeContext(context.Background(), "key/010", "key/019", lsm_tree.SearchRangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace := tracer.last(); trace.Operation != lsm_tree.OperationSearchRange || trace.TablesProbed != 3 || trace.RecordsRead != 3*res.Count {
+		t.Fatalf("unexpected trace %+v for %d keys", trace, res.Count)
+	}
+}
+
+func TestWriteTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	tree := openMemTree(t, lsm_tree.Options{Tracer: tracer, MergeOperator: lsm_tree.UInt64Add})
+
+	if err := tree.AddWithTTL("expiring", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if trace := tracer.last(); trace.Operation != lsm_tree.OperationAddWithTTL || trace.Key != "expiring" || trace.Err != nil {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if err := tree.Merge("counter", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if trace := tracer.last(); trace.Operation != lsm_tree.OperationMerge || trace.Key != "counter" || trace.Err != nil {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if err := tree.Merge("counter", "x"); err == nil {
+		t.Fatal("merging an invalid operand succeeded")
+	}
+	if trace := tracer.last(); trace.Operation != lsm_tree.OperationMerge || !errors.Is(trace.Err, lsm_tree.ErrMerging) {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+
+	// Both go through the context checks of the other writes.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.AddWithTTLContext(ctx, "cancelled", time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AddWithTTLContext returned %v", err)
+	}
+	if err := tree.MergeContext(ctx, "counter", "1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("MergeContext returned %v", err)
+	}
+	checkValue(t, tree, "cancelled", "", false)
+	checkValue(t, tree, "counter", "1", true)
+}
+
+func TestContextCancellation(t *testing.T) {
+	tracer := &recordingTracer{}
+	tree := openMemTree(t, lsm_tree.Options{Tracer: tracer})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.AddContext(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AddContext returned %v", err)
+	}
+	if _, err := tree.SearchRangeContext(ctx, "a", "z", lsm_tree.SearchRangeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SearchRangeContext returned %v", err)
+	}
+	if found, err := tree.SearchKey("key"); err != nil || found {
+		t.Fatalf("cancelled write is visible: %v, %v", found, err)
+	}
+
+	// The tracer of the write holds the tree lock until the read gives up.
+	tracer.block, tracer.blocked = make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- tree.Add("key")
+	}()
+	<-tracer.blocked
+	timeoutCtx, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	if _, _, err := tree.GetContext(timeoutCtx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetContext returned %v", err)
+	}
+	close(tracer.block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	tracer.block = nil
+	checkValue(t, tree, "key", "", true)
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func checkLiveKeys(t *testing.T, tree *lsm_tree.LSMTree, live []string, expired []string) {
+	t.Helper()
+
+	for _, key := range live {
+		if ok, err := tree.SearchKey(key); err != nil || !ok {
+			t.Fatalf("SearchKey(%s) = %v, %v", key, ok, err)
+		}
+	}
+	for _, key := range expired {
+		if ok, err := tree.SearchKey(key); err != nil || ok {
+			t.Fatalf("SearchKey(%s) = %v, %v for an expired key", key, ok, err)
+		}
+	}
+	if keys := allKeys(t, tree); !slices.Equal(keys, live) {
+		t.Fatalf("range returned %v, expected %v", keys, live)
+	}
+	if count := countKeys(t, tree); count != len(live) {
+		t.Fatalf("range counted %d keys, expected %d", count, len(live))
+	}
+}
+
+func TestTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	tree := openMemTree(t, lsm_tree.Options{Now: clock.Now})
+
+	if err := tree.AddWithTTL("a", 0); !errors.Is(err, lsm_tree.ErrInvalidTTL) {
+		t.Fatalf("adding with a zero ttl returned %v", err)
+	}
+
+	// "d" has a permanent older version on disk that the expired one hides.
+	steps := []func() error{
+		func() error { return tree.Add("d") },
+		tree.Flush,
+		func() error { return tree.AddWithTTL("a", time.Hour) },
+		func() error { return tree.Add("b") },
+		func() error { return tree.AddWithTTL("c", 3*time.Hour) },
+		func() error { return tree.AddWithTTL("d", time.Hour) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkLiveKeys(t, tree, []string{"a", "b", "c", "d"}, nil)
+
+	clock.now = clock.now.Add(2 * time.Hour)
+	checkLiveKeys(t, tree, []string{"b", "c"}, []string{"a", "d"})
+
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkLiveKeys(t, tree, []string{"b", "c"}, []string{"a", "d"})
+
+	var dump bytes.Buffer
+	if err := tree.Export(&dump); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.CompactAll(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLiveKeys(t, tree, []string{"b", "c"}, []string{"a", "d"})
+	if count, err := tree.ApproximateCount("a", "z"); err != nil || count != 2 {
+		t.Fatalf("%d elements left after compaction, %v", count, err)
+	}
+
+	// The dump keeps the expiry time of "c".
+	imported := openMemTree(t, lsm_tree.Options{Now: clock.Now})
+	if err := imported.Import(&dump); err != nil {
+		t.Fatal(err)
+	}
+	checkLiveKeys(t, imported, []string{"b", "c"}, []string{"a", "d"})
+
+	clock.now = clock.now.Add(2 * time.Hour)
+	checkLiveKeys(t, tree, []string{"b"}, []string{"a", "c", "d"})
+	checkLiveKeys(t, imported, []string{"b"}, []string{"a", "c", "d"})
+}
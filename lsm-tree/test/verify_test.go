@@ -0,0 +1,367 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"hw1/cmd/lsm_tree"
+	"hw1/internal/common"
+	"hw1/internal/comparator"
+	"hw1/internal/sstable"
+	"hw1/internal/vfs"
+)
+
+func rewriteFile(t *testing.T, fs vfs.FS, path string, change func([]byte) []byte) {
+	t.Helper()
+
+	file, err := fs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file)
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = fs.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.Write(change(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.SyncDir(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	fs := vfs.NewMemFS()
+	if err := fs.MkdirAll("tables", 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string) (string, string) {
+		metaPath, dataPath := "tables/"+name+".meta", "tables/"+name+".data"
+		w, err := sstable.NewSSTWriter(fs, metaPath, dataPath, comparator.Bytewise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 100 {
+			key := fmt.Sprintf("key-%03d", i)
+			switch i % 3 {
+			case 0:
+				err = w.Add(key)
+			case 1:
+				err = w.Delete(key)
+			case 2:
+				err = w.AddWithExpiry(key, time.Unix(0, int64(i)))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		return metaPath, dataPath
+	}
+
+	metaPath, dataPath := write("intact")
+	if err := sstable.Verify(fs, metaPath, dataPath, comparator.Bytewise); err != nil {
+		t.Fatal(err)
+	}
+
+	corruptions := map[string]func(metaPath string, dataPath string){
+		"flipped data byte": func(_ string, dataPath string) {
+			rewriteFile(t, fs, dataPath, func(data []byte) []byte {
+				data[len(data)/2] ^= 0xff
+				return data
+			})
+		},
+		"flipped index byte": func(metaPath string, _ string) {
+			rewriteFile(t, fs, metaPath, func(data []byte) []byte {
+				data[20] ^= 0xff
+				return data
+			})
+		},
+		"truncated data": func(_ string, dataPath string) {
+			rewriteFile(t, fs, dataPath, func(data []byte) []byte { return data[:len(data)-3] })
+		},
+		"truncated index": func(metaPath string, _ string) {
+			rewriteFile(t, fs, metaPath, func(data []byte) []byte { return data[:len(data)/2] })
+		},
+		"huge comparator name length": func(metaPath string, _ string) {
+			rewriteFile(t, fs, metaPath, func(data []byte) []byte {
+				name := bytes.LastIndex(data, []byte(comparator.Bytewise.Name()))
+				binary.LittleEndian.PutUint64(data[name-8:name], math.MaxInt64)
+				return data
+			})
+		},
+		"huge key length": func(metaPath string, _ string) {
+			rewriteFile(t, fs, metaPath, func(data []byte) []byte {
+				binary.LittleEndian.PutUint64(data[8:16], math.MaxInt64)
+				return data
+			})
+		},
+	}
+	for name, corrupt := range corruptions {
+		metaPath, dataPath := write(name)
+		corrupt(metaPath, dataPath)
+
+		err := sstable.Verify(fs, metaPath, dataPath, comparator.Bytewise)
+		if !errors.Is(err, sstable.ErrCorruptedTable) {
+			t.Fatalf("%s: Verify = %v, expected %v", name, err, sstable.ErrCorruptedTable)
+		}
+	}
+
+	// Open reads every record, and the lengths it reads from a corrupted
+	// index are checked rather than allocated.
+	metaPath, dataPath = write("read")
+	rewriteFile(t, fs, metaPath, func(data []byte) []byte {
+		binary.LittleEndian.PutUint64(data[50*16+8:50*16+16], math.MaxInt64)
+		return data
+	})
+	if _, err := sstable.Open(fs, metaPath, dataPath, comparator.Bytewise); !errors.Is(err, sstable.ErrCorruptedTable) {
+		t.Fatalf("opening a table with a huge key length = %v, expected %v", err, sstable.ErrCorruptedTable)
+	}
+
+	metaPath, dataPath = write("reverse")
+	err := sstable.Verify(fs, metaPath, dataPath, comparator.Reverse(comparator.Bytewise))
+	if !errors.Is(err, sstable.ErrComparatorMismatch) {
+		t.Fatalf("Verify = %v, expected %v", err, sstable.ErrComparatorMismatch)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := lsm_tree.Options{FS: fs, Dir: "db"}
+	tree, err := lsm_tree.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three tables: all keys, then a half deleted, then another range.
+	expected := make(map[string]bool)
+	for i := range 200 {
+		key := fmt.Sprintf("key-%03d", i)
+		if err = tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = true
+	}
+	if err = tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		key := fmt.Sprintf("key-%03d", i)
+		if err = tree.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = false
+	}
+	if err = tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		if err = tree.Add(fmt.Sprintf("other-%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last table loses its second half and the manifest is gone.
+	metaPath := filepath.Join("db", common.MetaDataDir, "2")
+	dataPath := filepath.Join("db", common.DataDir, "2")
+	if err = sstable.Verify(fs, metaPath, dataPath, comparator.Bytewise); err != nil {
+		t.Fatal(err)
+	}
+	rewriteFile(t, fs, dataPath, func(data []byte) []byte { return data[:len(data)/2] })
+	if err = fs.Remove(filepath.Join("db", common.ManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := lsm_tree.Repair(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tables != 3 || report.Unreadable != 0 || report.Lost == 0 || report.Recovered+report.Lost != 400 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	tree, err = lsm_tree.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	checkKeys(t, tree, expected)
+	keys, err := tree.SearchRange("other-000", "other-999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 100-report.Lost || keys[0] != "other-000" {
+		t.Fatalf("%d keys of the damaged table survived, expected %d", len(keys), 100-report.Lost)
+	}
+
+	// The damaged table is kept for inspection, out of the way of the tree.
+	for _, path := range []string{
+		filepath.Join("db", common.LostDir, common.MetaDataDir, "2"),
+		filepath.Join("db", common.LostDir, common.DataDir, "2"),
+	} {
+		if _, err = fs.Open(path); err != nil {
+			t.Fatalf("damaged table file %s: %v", path, err)
+		}
+	}
+
+	levels, err := tree.Levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, runs := range levels {
+		for _, infos := range runs {
+			for _, info := range infos {
+				if err = sstable.Verify(fs, info.MetaPath, info.DataPath, comparator.Bytewise); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func TestRepairSalvagesUnlistedTables(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := lsm_tree.Options{FS: fs, Dir: "db"}
+	tree, err := lsm_tree.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err = tree.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A table the manifest doesn't list, as left by an interrupted merge,
+	// and the index of another one without its data file.
+	metaPath, dataPath := filepath.Join("db", common.MetaDataDir, "10"), filepath.Join("db", common.DataDir, "10")
+	w, err := sstable.NewSSTWriter(fs, metaPath, dataPath, comparator.Bytewise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"b", "unlisted"} {
+		if err = w.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join("db", common.MetaDataDir, "11")
+	file, err := fs.Create(orphan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	report, err := lsm_tree.Repair(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tables != 3 || report.Unreadable != 1 || report.Recovered != 4 || report.Lost != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if _, err = fs.Open(filepath.Join("db", common.LostDir, common.MetaDataDir, "11")); err != nil {
+		t.Fatalf("the unreadable table was not kept: %v", err)
+	}
+
+	tree, err = lsm_tree.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if keys := allKeys(t, tree); !slices.Equal(keys, []string{"a", "b", "unlisted"}) {
+		t.Fatalf("keys %v after the repair, expected [a b unlisted]", keys)
+	}
+}
+
+// TestCrashDuringRepair interrupts a repair at every write: opening the tree
+// must not collect the damaged table before it's in the lost directory.
+func TestCrashDuringRepair(t *testing.T) {
+	damagedTree := func(t *testing.T) *vfs.MemFS {
+		fs := vfs.NewMemFS()
+		tree, err := lsm_tree.Open(lsm_tree.Options{FS: fs, Dir: "db"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"a", "b"} {
+			if err = tree.Add(key); err != nil {
+				t.Fatal(err)
+			}
+			if err = tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rewriteFile(t, fs, filepath.Join("db", common.DataDir, "1"), func(data []byte) []byte { return data[:len(data)/2] })
+		return fs
+	}
+
+	dryRun := vfs.NewFaultFS(damagedTree(t))
+	start := dryRun.Writes()
+	if _, err := lsm_tree.Repair(lsm_tree.Options{FS: dryRun, Dir: "db"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for crashPoint := range dryRun.Writes() - start + 1 {
+		t.Run(fmt.Sprintf("crash after %d writes", crashPoint), func(t *testing.T) {
+			t.Parallel()
+
+			fs := vfs.NewFaultFS(damagedTree(t))
+			opts := lsm_tree.Options{FS: fs, Dir: "db"}
+			fs.SetWriteLimit(crashPoint)
+			_, _ = lsm_tree.Repair(opts)
+			fs.Crash()
+
+			// The tree can't be opened if the manifest still lists the
+			// moved table, the repair is run again then.
+			if tree, err := lsm_tree.Open(opts); err == nil {
+				if err = tree.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := lsm_tree.Repair(opts); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{
+				filepath.Join("db", common.LostDir, common.MetaDataDir, "1"),
+				filepath.Join("db", common.LostDir, common.DataDir, "1"),
+			} {
+				if _, err := fs.Open(path); err != nil {
+					t.Fatalf("damaged table file %s: %v", path, err)
+				}
+			}
+		})
+	}
+}